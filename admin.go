@@ -0,0 +1,439 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+var adminLog = newLogger("admin")
+
+func (b *PlayerBan) message() string {
+	message := "you are banned"
+	if b.Until != nil {
+		message += " until " + b.Until.Format(time.RFC3339)
+	}
+	if b.Reason != "" {
+		message += ": " + b.Reason
+	}
+	return message
+}
+
+// registerAdminRoutes mounts the admin API on mux. Every route requires the
+// configured bearer token; without one the API is not served at all.
+func registerAdminRoutes(mux *http.ServeMux, hub *Hub) {
+	token := hub.config.Admin.Token
+	if token == "" {
+		adminLog.Info("admin API disabled, no token configured")
+		return
+	}
+	handle := func(pattern string, handler func(*Hub, http.ResponseWriter, *http.Request)) {
+		mux.Handle(pattern, requireAdminToken(token, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handler(hub, w, r)
+		})))
+	}
+	handle("GET /admin/clients", handleAdminClients)
+	handle("GET /admin/games", handleAdminGames)
+	handle("GET /admin/games/{id}", handleAdminGame)
+	handle("POST /admin/games/{id}/end", handleAdminEndGame)
+	handle("POST /admin/games/{id}/void", handleAdminVoidGame)
+	handle("POST /admin/players/{id}/kick", handleAdminKick)
+	handle("POST /admin/players/{id}/ban", handleAdminBan)
+	handle("DELETE /admin/players/{id}/ban", handleAdminUnban)
+	handle("GET /admin/bans", handleAdminBans)
+	handle("GET /admin/queue", handleAdminQueue)
+	handle("DELETE /admin/queue", handleAdminFlushQueue)
+	handle("PUT /admin/leaderboards/{metric}/{playerId}", handleAdminSetScore)
+	handle("DELETE /admin/leaderboards/{metric}/{playerId}", handleAdminRemoveScore)
+	handle("POST /admin/announcements", handleAdminAnnouncement)
+	handle("GET /admin/maintenance", handleAdminMaintenance)
+	handle("PUT /admin/maintenance", handleAdminStartMaintenance)
+	handle("DELETE /admin/maintenance", handleAdminEndMaintenance)
+	handle("GET /admin/reaper/report", handleAdminReaperReport)
+	adminLog.Info("admin API enabled")
+}
+
+func requireAdminToken(token string, next http.Handler) http.Handler {
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			adminLog.Warn("rejected admin request", "method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// readJSON decodes an optional request body into v.
+func readJSON(r *http.Request, v any) error {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
+
+func handleAdminClients(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"replica": replicaID,
+		"clients": hub.connections(),
+	})
+}
+
+// handleAdminGames lists every unfinished game with a player marked busy in
+// it, across all replicas.
+func handleAdminGames(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	players, err := store.PlayersInGame(r.Context())
+	if err != nil {
+		adminLog.Error("error listing players in game", "error", err)
+		http.Error(w, "could not list games", http.StatusInternalServerError)
+		return
+	}
+	seen := make(map[string]bool)
+	games := []*Game{}
+	for _, playerID := range players {
+		gameID, err := store.PlayerGame(r.Context(), playerID)
+		if err != nil || seen[gameID] {
+			continue
+		}
+		seen[gameID] = true
+		if game, err := getGame(r.Context(), gameID); err == nil && !game.isOver() {
+			games = append(games, game)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"games": games})
+}
+
+func handleAdminGame(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	gameID := r.PathValue("id")
+	events, err := store.GameEvents(r.Context(), gameID, "")
+	if err != nil {
+		adminLog.Error("error loading game events", "game_id", gameID, "error", err)
+		http.Error(w, "could not load game", http.StatusInternalServerError)
+		return
+	}
+	if len(events) == 0 {
+		http.Error(w, "game not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, GameEventsResponse{GameID: gameID, Game: foldGameEvents(events), Events: events})
+}
+
+func handleAdminEndGame(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Result string `json:"result"`
+	}
+	if err := readJSON(r, &request); err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+	switch request.Result {
+	case StatusWinX, StatusWinO, StatusDraw:
+	default:
+		http.Error(w, `result must be "win_x", "win_o" or "draw"`, http.StatusBadRequest)
+		return
+	}
+	forceFinishGame(hub, w, r, request.Result)
+}
+
+func handleAdminVoidGame(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	forceFinishGame(hub, w, r, StatusVoid)
+}
+
+// forceFinishGame ends a live game with the given status and runs the
+// usual end-of-game bookkeeping, so a forced win is credited like any
+// other and a voided game is credited to nobody.
+func forceFinishGame(hub *Hub, w http.ResponseWriter, r *http.Request, status string) {
+	gameID := r.PathValue("id")
+	game, err := getGame(r.Context(), gameID)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, "game not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "could not load game", http.StatusInternalServerError)
+		return
+	}
+	if game.isOver() {
+		http.Error(w, "game is already over", http.StatusConflict)
+		return
+	}
+	if err := recordGameEvents(r.Context(), hub, game, GameEvent{Type: EventFinished, Status: status}); err != nil {
+		http.Error(w, "game changed while ending it, try again", http.StatusConflict)
+		return
+	}
+	adminLog.Info("game ended by admin", "game_id", gameID, "status", status, "remote_addr", r.RemoteAddr)
+	cancelDeadline(DeadlineForfeit, game.ID, game.PlayerX)
+	cancelDeadline(DeadlineForfeit, game.ID, game.PlayerO)
+	publishGameUpdate(r.Context(), hub, game)
+	finishGame(r.Context(), hub, game)
+	writeJSON(w, http.StatusOK, game)
+}
+
+func handleAdminKick(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Reason string `json:"reason"`
+	}
+	if err := readJSON(r, &request); err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+	playerID := r.PathValue("id")
+	message := "disconnected by an administrator"
+	if request.Reason != "" {
+		message += ": " + request.Reason
+	}
+	connected := isOnline(r.Context(), playerID)
+	if err := disconnectPlayer(r, playerID, ErrorPayload{Code: "kicked", Message: message}); err != nil {
+		http.Error(w, "could not reach the player's replicas", http.StatusInternalServerError)
+		return
+	}
+	adminLog.Info("player kicked", "player_id", playerID, "connected", connected, "remote_addr", r.RemoteAddr)
+	writeJSON(w, http.StatusOK, map[string]any{"playerId": playerID, "connected": connected})
+}
+
+// disconnectPlayer sends the error to every connection the player has, on
+// any replica, and closes them.
+func disconnectPlayer(r *http.Request, playerID string, payload ErrorPayload) error {
+	message, _ := json.Marshal(Message{Type: "error", Payload: payload})
+	return routeDirect(r.Context(), &directMessage{playerID: playerID, message: message, disconnect: true})
+}
+
+func handleAdminBan(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Reason   string `json:"reason"`
+		Duration string `json:"duration"`
+	}
+	if err := readJSON(r, &request); err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+	now := time.Now().UTC()
+	ban := &PlayerBan{PlayerID: r.PathValue("id"), Reason: request.Reason, BannedAt: now}
+	if request.Duration != "" {
+		duration, err := time.ParseDuration(request.Duration)
+		if err != nil || duration <= 0 {
+			http.Error(w, "duration must be a positive Go duration such as 24h", http.StatusBadRequest)
+			return
+		}
+		until := now.Add(duration)
+		ban.Until = &until
+	}
+	if err := store.BanPlayer(r.Context(), ban); err != nil {
+		adminLog.Error("error saving ban", "player_id", ban.PlayerID, "error", err)
+		http.Error(w, "could not save ban", http.StatusInternalServerError)
+		return
+	}
+	adminLog.Info("player banned", "player_id", ban.PlayerID, "until", ban.Until, "reason", ban.Reason, "remote_addr", r.RemoteAddr)
+	// The ban itself is stored; a failure below means it is not fully in
+	// effect yet, so the operator gets a 5xx and can retry.
+	if err := store.RemoveFromQueue(r.Context(), ban.PlayerID); err != nil {
+		adminLog.Error("error dequeueing banned player", "player_id", ban.PlayerID, "error", err)
+		http.Error(w, "ban recorded, but the player could not be removed from the matchmaking queue", http.StatusInternalServerError)
+		return
+	}
+	if err := disconnectPlayer(r, ban.PlayerID, ErrorPayload{Code: "banned", Message: ban.message()}); err != nil {
+		adminLog.Error("error disconnecting banned player", "player_id", ban.PlayerID, "error", err)
+		http.Error(w, "ban recorded, but the player's replicas could not be reached to close their connections", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, ban)
+}
+
+func handleAdminUnban(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	playerID := r.PathValue("id")
+	if err := store.UnbanPlayer(r.Context(), playerID); err != nil {
+		adminLog.Error("error removing ban", "player_id", playerID, "error", err)
+		http.Error(w, "could not remove ban", http.StatusInternalServerError)
+		return
+	}
+	adminLog.Info("player unbanned", "player_id", playerID, "remote_addr", r.RemoteAddr)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleAdminBans(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	bans, err := store.Bans(r.Context())
+	if err != nil {
+		adminLog.Error("error listing bans", "error", err)
+		http.Error(w, "could not list bans", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"bans": bans})
+}
+
+type queuedPlayer struct {
+	PlayerID string `json:"playerId"`
+	Name     string `json:"name"`
+}
+
+func handleAdminQueue(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	playerIDs, err := store.QueuedPlayers(r.Context())
+	if err != nil {
+		adminLog.Error("error listing queued players", "error", err)
+		http.Error(w, "could not list queue", http.StatusInternalServerError)
+		return
+	}
+	names, _ := store.GetPlayerNames(r.Context(), playerIDs)
+	players := make([]queuedPlayer, len(playerIDs))
+	for i, playerID := range playerIDs {
+		players[i] = queuedPlayer{PlayerID: playerID}
+		if i < len(names) {
+			players[i].Name = names[i]
+		}
+	}
+	length, _ := store.QueueLength(r.Context())
+	writeJSON(w, http.StatusOK, map[string]any{"length": length, "players": players})
+}
+
+// handleAdminFlushQueue empties the matchmaking queue and tells each
+// removed player, so their clients stop waiting for a match.
+func handleAdminFlushQueue(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	playerIDs, err := store.QueuedPlayers(r.Context())
+	if err != nil {
+		adminLog.Error("error listing queued players", "error", err)
+		http.Error(w, "could not list queue", http.StatusInternalServerError)
+		return
+	}
+	message, _ := json.Marshal(Message{Type: "error", Payload: ErrorPayload{
+		Code:    "queue_flushed",
+		Message: "the matchmaking queue was reset, search again",
+	}})
+	for _, playerID := range playerIDs {
+		store.RemoveFromQueue(r.Context(), playerID)
+		routeDirect(r.Context(), &directMessage{playerID: playerID, message: message})
+	}
+	adminLog.Info("matchmaking queue flushed", "players", len(playerIDs), "remote_addr", r.RemoteAddr)
+	writeJSON(w, http.StatusOK, map[string]any{"removed": playerIDs})
+}
+
+func handleAdminSetScore(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	key, ok := allTimeLeaderboardKey(r.PathValue("metric"))
+	if !ok {
+		http.Error(w, "unknown leaderboard metric", http.StatusNotFound)
+		return
+	}
+	var request struct {
+		Score *float64 `json:"score"`
+	}
+	if err := readJSON(r, &request); err != nil || request.Score == nil {
+		http.Error(w, `body must be {"score": number}`, http.StatusBadRequest)
+		return
+	}
+	playerID := r.PathValue("playerId")
+	if err := store.SetScore(r.Context(), key, playerID, *request.Score); err != nil {
+		adminLog.Error("error setting score", "board", key, "player_id", playerID, "error", err)
+		http.Error(w, "could not set score", http.StatusInternalServerError)
+		return
+	}
+	adminLog.Info("leaderboard score set", "board", key, "player_id", playerID, "score", *request.Score, "remote_addr", r.RemoteAddr)
+	writeJSON(w, http.StatusOK, map[string]any{"metric": r.PathValue("metric"), "playerId": playerID, "score": *request.Score})
+}
+
+func handleAdminRemoveScore(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	key, ok := allTimeLeaderboardKey(r.PathValue("metric"))
+	if !ok {
+		http.Error(w, "unknown leaderboard metric", http.StatusNotFound)
+		return
+	}
+	playerID := r.PathValue("playerId")
+	if err := store.RemoveScore(r.Context(), key, playerID); err != nil {
+		adminLog.Error("error removing score", "board", key, "player_id", playerID, "error", err)
+		http.Error(w, "could not remove score", http.StatusInternalServerError)
+		return
+	}
+	adminLog.Info("leaderboard entry removed", "board", key, "player_id", playerID, "remote_addr", r.RemoteAddr)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAdminAnnouncement broadcasts a server_announcement to every client
+// on every replica.
+func handleAdminAnnouncement(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Message string `json:"message"`
+	}
+	if err := readJSON(r, &request); err != nil || request.Message == "" {
+		http.Error(w, `body must be {"message": string}`, http.StatusBadRequest)
+		return
+	}
+	announcement := ServerAnnouncementPayload{Kind: AnnouncementMessage, Message: request.Message, SentAt: time.Now().UTC()}
+	data, _ := json.Marshal(Message{Type: "server_announcement", Payload: announcement})
+	if err := store.PublishBroadcast(r.Context(), data); err != nil {
+		adminLog.Error("error publishing announcement", "error", err)
+		http.Error(w, "could not publish announcement", http.StatusInternalServerError)
+		return
+	}
+	adminLog.Info("announcement broadcast", "remote_addr", r.RemoteAddr)
+	writeJSON(w, http.StatusAccepted, announcement)
+}
+
+func handleAdminMaintenance(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	maintenance, err := store.Maintenance(r.Context())
+	if errors.Is(err, ErrNotFound) {
+		writeJSON(w, http.StatusOK, map[string]any{"enabled": false})
+		return
+	}
+	if err != nil {
+		adminLog.Error("error reading maintenance flag", "error", err)
+		http.Error(w, "could not read maintenance flag", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"enabled": true, "maintenance": maintenance})
+}
+
+// handleAdminStartMaintenance switches maintenance mode on for every
+// replica, or updates its message and expected end if it is already on.
+func handleAdminStartMaintenance(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Message  string `json:"message"`
+		Duration string `json:"duration"`
+	}
+	if err := readJSON(r, &request); err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+	now := time.Now().UTC()
+	maintenance := &Maintenance{Message: request.Message, StartedAt: now}
+	if current, err := store.Maintenance(r.Context()); err == nil && !current.StartedAt.IsZero() {
+		maintenance.StartedAt = current.StartedAt
+	}
+	if request.Duration != "" {
+		duration, err := time.ParseDuration(request.Duration)
+		if err != nil || duration <= 0 {
+			http.Error(w, "duration must be a positive Go duration such as 15m", http.StatusBadRequest)
+			return
+		}
+		endsAt := now.Add(duration)
+		maintenance.EndsAt = &endsAt
+	}
+	if err := store.SetMaintenance(r.Context(), maintenance); err != nil {
+		adminLog.Error("error setting maintenance flag", "error", err)
+		http.Error(w, "could not set maintenance flag", http.StatusInternalServerError)
+		return
+	}
+	adminLog.Info("maintenance mode set", "ends_at", maintenance.EndsAt, "remote_addr", r.RemoteAddr)
+	// Other replicas notice within maintenance.poll_interval.
+	refreshMaintenance(r.Context(), hub)
+	writeJSON(w, http.StatusOK, map[string]any{"enabled": true, "maintenance": maintenance})
+}
+
+func handleAdminEndMaintenance(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if err := store.ClearMaintenance(r.Context()); err != nil {
+		adminLog.Error("error clearing maintenance flag", "error", err)
+		http.Error(w, "could not clear maintenance flag", http.StatusInternalServerError)
+		return
+	}
+	adminLog.Info("maintenance mode cleared", "remote_addr", r.RemoteAddr)
+	refreshMaintenance(r.Context(), hub)
+	w.WriteHeader(http.StatusNoContent)
+}
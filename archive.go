@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+const defaultArchiveDSN = "file:games.db?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+const maxHistoryLimit = 50
+
+var archiveSchema = []string{
+	`CREATE TABLE IF NOT EXISTS games (
+		id TEXT PRIMARY KEY,
+		variant TEXT NOT NULL,
+		player_x TEXT NOT NULL,
+		player_o TEXT NOT NULL,
+		player_x_name TEXT NOT NULL,
+		player_o_name TEXT NOT NULL,
+		result TEXT NOT NULL,
+		winner TEXT,
+		tournament_id TEXT,
+		started_at TIMESTAMP NOT NULL,
+		finished_at TIMESTAMP NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS game_moves (
+		game_id TEXT NOT NULL REFERENCES games(id),
+		seq INTEGER NOT NULL,
+		player TEXT NOT NULL,
+		cell INTEGER NOT NULL,
+		played_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (game_id, seq)
+	)`,
+	`CREATE INDEX IF NOT EXISTS games_player_x_idx ON games (player_x, finished_at)`,
+	`CREATE INDEX IF NOT EXISTS games_player_o_idx ON games (player_o, finished_at)`,
+}
+
+// gameArchive is the relational record of finished games. Redis only keeps
+// live games; history and lifetime stats are served from here.
+type gameArchive struct {
+	db       *sql.DB
+	postgres bool
+}
+
+type ArchivedGame struct {
+	ID           string    `json:"id"`
+	Variant      string    `json:"variant"`
+	PlayerX      string    `json:"playerX"`
+	PlayerO      string    `json:"playerO"`
+	PlayerXName  string    `json:"playerXName"`
+	PlayerOName  string    `json:"playerOName"`
+	Result       string    `json:"result"`
+	Winner       string    `json:"winner,omitempty"`
+	TournamentID string    `json:"tournamentId,omitempty"`
+	StartedAt    time.Time `json:"startedAt"`
+	FinishedAt   time.Time `json:"finishedAt"`
+	Moves        []Move    `json:"moves"`
+}
+
+type PlayerRecord struct {
+	PlayerID      string     `json:"playerId"`
+	Games         int64      `json:"games"`
+	Wins          int64      `json:"wins"`
+	Losses        int64      `json:"losses"`
+	Draws         int64      `json:"draws"`
+	Abandoned     int64      `json:"abandoned"`
+	FirstPlayedAt *time.Time `json:"firstPlayedAt,omitempty"`
+	LastPlayedAt  *time.Time `json:"lastPlayedAt,omitempty"`
+}
+
+type GameHistory struct {
+	PlayerID string         `json:"playerId"`
+	Offset   int            `json:"offset"`
+	Limit    int            `json:"limit"`
+	Games    []ArchivedGame `json:"games"`
+}
+
+var archiveLog = newLogger("archive")
+
+var archive *gameArchive
+
+// initArchive opens the archive selected by cfg.Driver ("sqlite", "postgres",
+// or "none" to disable it). Config.validate has already checked the pair.
+func initArchive(cfg ArchiveConfig) {
+	driver, dsn := cfg.Driver, cfg.DSN
+	switch driver {
+	case "none":
+		archiveLog.Info("game archive disabled")
+		return
+	case "sqlite":
+		if dsn == "" {
+			dsn = defaultArchiveDSN
+		}
+	case "postgres":
+		driver = "pgx"
+	}
+
+	a, err := openArchive(driver, dsn)
+	if err != nil {
+		fatal(archiveLog, "could not open archive", "driver", driver, "error", err)
+	}
+	archive = a
+	archiveLog.Info("archiving finished games", "driver", driver)
+}
+
+func openArchive(driver string, dsn string) (*gameArchive, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if driver == "sqlite" {
+		// SQLite allows a single writer; serialising access avoids
+		// SQLITE_BUSY under concurrent game endings.
+		db.SetMaxOpenConns(1)
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	for _, statement := range archiveSchema {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("applying schema: %w", err)
+		}
+	}
+	return &gameArchive{db: db, postgres: driver == "pgx"}, nil
+}
+
+// rebind rewrites ? placeholders as $1, $2, ... for Postgres.
+func (a *gameArchive) rebind(query string) string {
+	if !a.postgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// ArchiveGame records a finished game and its moves. Archiving the same game
+// twice is a no-op.
+func (a *gameArchive) ArchiveGame(ctx context.Context, game *Game) error {
+	finishedAt := time.Now().UTC()
+	if game.FinishedAt != nil {
+		finishedAt = *game.FinishedAt
+	}
+	startedAt := game.CreatedAt
+	if startedAt.IsZero() {
+		startedAt = finishedAt
+	}
+	variant := game.Variant
+	if variant == "" {
+		variant = VariantClassic
+	}
+	var winner sql.NullString
+	switch game.Status {
+	case StatusWinX:
+		winner = sql.NullString{String: game.PlayerX, Valid: true}
+	case StatusWinO:
+		winner = sql.NullString{String: game.PlayerO, Valid: true}
+	}
+	tournamentID := sql.NullString{String: game.TournamentID, Valid: game.TournamentID != ""}
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, a.rebind(`INSERT INTO games
+		(id, variant, player_x, player_o, player_x_name, player_o_name, result, winner, tournament_id, started_at, finished_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO NOTHING`),
+		game.ID, variant, game.PlayerX, game.PlayerO, game.PlayerXName, game.PlayerOName,
+		game.Status, winner, tournamentID, startedAt, finishedAt)
+	if err != nil {
+		return err
+	}
+	if inserted, err := result.RowsAffected(); err == nil && inserted == 0 {
+		return nil
+	}
+	for i, move := range game.Moves {
+		if _, err := tx.ExecContext(ctx, a.rebind(`INSERT INTO game_moves (game_id, seq, player, cell, played_at) VALUES (?, ?, ?, ?, ?)`),
+			game.ID, i+1, move.Player, move.Index, move.PlayedAt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// PlayerHistory returns a player's finished games, newest first.
+func (a *gameArchive) PlayerHistory(ctx context.Context, playerID string, limit int, offset int) ([]ArchivedGame, error) {
+	rows, err := a.db.QueryContext(ctx, a.rebind(`SELECT id, variant, player_x, player_o, player_x_name, player_o_name,
+			result, winner, tournament_id, started_at, finished_at
+		FROM games
+		WHERE player_x = ? OR player_o = ?
+		ORDER BY finished_at DESC
+		LIMIT ? OFFSET ?`), playerID, playerID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	games := []ArchivedGame{}
+	index := make(map[string]int)
+	for rows.Next() {
+		var game ArchivedGame
+		var winner, tournamentID sql.NullString
+		if err := rows.Scan(&game.ID, &game.Variant, &game.PlayerX, &game.PlayerO, &game.PlayerXName, &game.PlayerOName,
+			&game.Result, &winner, &tournamentID, &game.StartedAt, &game.FinishedAt); err != nil {
+			return nil, err
+		}
+		game.Winner = winner.String
+		game.TournamentID = tournamentID.String
+		game.Moves = []Move{}
+		index[game.ID] = len(games)
+		games = append(games, game)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(games) == 0 {
+		return games, nil
+	}
+
+	placeholders := make([]string, len(games))
+	args := make([]interface{}, len(games))
+	for i, game := range games {
+		placeholders[i] = "?"
+		args[i] = game.ID
+	}
+	moveRows, err := a.db.QueryContext(ctx, a.rebind(`SELECT game_id, player, cell, played_at
+		FROM game_moves
+		WHERE game_id IN (`+strings.Join(placeholders, ", ")+`)
+		ORDER BY game_id, seq`), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer moveRows.Close()
+	for moveRows.Next() {
+		var gameID string
+		var move Move
+		if err := moveRows.Scan(&gameID, &move.Player, &move.Index, &move.PlayedAt); err != nil {
+			return nil, err
+		}
+		games[index[gameID]].Moves = append(games[index[gameID]].Moves, move)
+	}
+	return games, moveRows.Err()
+}
+
+// PlayerRecord aggregates a player's lifetime results. Voided games are
+// not counted.
+func (a *gameArchive) PlayerRecord(ctx context.Context, playerID string) (*PlayerRecord, error) {
+	record := &PlayerRecord{PlayerID: playerID}
+	var wins, draws, abandoned sql.NullInt64
+	err := a.db.QueryRowContext(ctx, a.rebind(`SELECT COUNT(*),
+			SUM(CASE WHEN winner = ? THEN 1 ELSE 0 END),
+			SUM(CASE WHEN result = ? THEN 1 ELSE 0 END),
+			SUM(CASE WHEN result = ? THEN 1 ELSE 0 END)
+		FROM games
+		WHERE (player_x = ? OR player_o = ?) AND result <> ?`), playerID, StatusDraw, StatusAbandoned, playerID, playerID, StatusVoid).
+		Scan(&record.Games, &wins, &draws, &abandoned)
+	if err != nil {
+		return nil, err
+	}
+	record.Wins = wins.Int64
+	record.Draws = draws.Int64
+	record.Abandoned = abandoned.Int64
+	record.Losses = record.Games - record.Wins - record.Draws - record.Abandoned
+	if record.Games == 0 {
+		return record, nil
+	}
+
+	// Read the bounds as plain rows rather than MIN/MAX so the driver keeps
+	// the column's timestamp type (SQLite returns aggregates as text).
+	for _, bound := range []struct {
+		order  string
+		target **time.Time
+	}{{"ASC", &record.FirstPlayedAt}, {"DESC", &record.LastPlayedAt}} {
+		var playedAt time.Time
+		err := a.db.QueryRowContext(ctx, a.rebind(`SELECT finished_at FROM games
+			WHERE (player_x = ? OR player_o = ?) AND result <> ?
+			ORDER BY finished_at `+bound.order+` LIMIT 1`), playerID, playerID, StatusVoid).Scan(&playedAt)
+		if err != nil {
+			return nil, err
+		}
+		*bound.target = &playedAt
+	}
+	return record, nil
+}
+
+// archiveFinishedGame writes a finished game to the archive and lets its
+// live copy expire after ttl. If the archive write fails the live copy is
+// kept and an archive deadline retries it.
+func archiveFinishedGame(game *Game, ttl time.Duration) {
+	if err := archiveGame(game, ttl); err != nil {
+		archiveLog.Error("error archiving game, retrying later", "game_id", game.ID, "error", err)
+		scheduleDeadline(DeadlineArchive, time.Now().Add(deadlineRetryDelay), game.ID)
+	}
+}
+
+func archiveGame(game *Game, ttl time.Duration) error {
+	if archive != nil {
+		if err := archive.ArchiveGame(ctx, game); err != nil {
+			return err
+		}
+		archiveLog.Info("game archived", "game_id", game.ID, "status", game.Status)
+	}
+	return store.ExpireGame(ctx, game.ID, ttl)
+}
+
+// handleArchiveDeadline retries archiving a finished game. A game that is
+// gone has already expired, so there is nothing left to do.
+func handleArchiveDeadline(hub *Hub, args []string) error {
+	game, err := getGame(ctx, args[0])
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return archiveGame(game, hub.config.Game.FinishedTTL)
+}
+
+// archiveRequestAllowed refuses an archive query when there is no archive
+// or no player to look up.
+func archiveRequestAllowed(client *Client, messageType, playerID string) bool {
+	switch {
+	case archive == nil:
+		client.logger().Info(messageType + " rejected, archive disabled")
+		client.sendError(ErrorPayload{Code: "archive_disabled", Message: "game history is not available on this server", MessageType: messageType})
+		return false
+	case playerID == "":
+		client.logger().Info(messageType + " rejected, no player given")
+		client.sendError(ErrorPayload{Code: "identify_required", Message: "send a playerId to " + messageType, MessageType: messageType})
+		return false
+	}
+	return true
+}
+
+func handleGetHistory(ctx context.Context, client *Client, payload interface{}) {
+	payloadData, _ := json.Marshal(payload)
+	var historyPayload HistoryPayload
+	if err := json.Unmarshal(payloadData, &historyPayload); err != nil {
+		client.logger().Warn("error unmarshalling get_history payload", "error", err)
+		return
+	}
+	playerID := historyPayload.PlayerID
+	if playerID == "" {
+		playerID = client.PlayerID
+	}
+	if !archiveRequestAllowed(client, "get_history", playerID) {
+		return
+	}
+
+	limit := historyPayload.Limit
+	if limit <= 0 || limit > maxHistoryLimit {
+		limit = maxHistoryLimit
+	}
+	offset := historyPayload.Offset
+	if offset < 0 {
+		offset = 0
+	}
+	games, err := archive.PlayerHistory(ctx, playerID, limit, offset)
+	if err != nil {
+		client.logger().Error("error loading history", "for_player_id", playerID, "error", err)
+		client.sendError(ErrorPayload{Code: "internal_error", Message: "the history could not be loaded, try again", MessageType: "get_history"})
+		return
+	}
+
+	response := Message{Type: "game_history", Payload: GameHistory{PlayerID: playerID, Offset: offset, Limit: limit, Games: games}}
+	responseJSON, _ := json.Marshal(response)
+	client.reply(responseJSON)
+}
+
+func handleGetPlayerStats(ctx context.Context, client *Client, payload interface{}) {
+	payloadData, _ := json.Marshal(payload)
+	var statsPayload HistoryPayload
+	if err := json.Unmarshal(payloadData, &statsPayload); err != nil {
+		client.logger().Warn("error unmarshalling get_player_stats payload", "error", err)
+		return
+	}
+	playerID := statsPayload.PlayerID
+	if playerID == "" {
+		playerID = client.PlayerID
+	}
+	if !archiveRequestAllowed(client, "get_player_stats", playerID) {
+		return
+	}
+
+	record, err := archive.PlayerRecord(ctx, playerID)
+	if err != nil {
+		client.logger().Error("error loading player stats", "for_player_id", playerID, "error", err)
+		client.sendError(ErrorPayload{Code: "internal_error", Message: "the player stats could not be loaded, try again", MessageType: "get_player_stats"})
+		return
+	}
+
+	response := Message{Type: "player_stats", Payload: record}
+	responseJSON, _ := json.Marshal(response)
+	client.reply(responseJSON)
+}
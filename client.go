@@ -3,19 +3,18 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"log/slog"
 	"net/http"
+	"sync/atomic"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin:     func(r *http.Request) bool { return true },
-}
-
 type Client struct {
 	ID         string
 	PlayerID   string
@@ -24,21 +23,68 @@ type Client struct {
 	hub        *Hub
 	conn       *websocket.Conn
 	send       chan []byte
+	// done is closed by the hub when it drops the connection. send is never
+	// closed, so late replies from handlers cannot panic; they go through
+	// reply, which gives up once done is closed.
+	done chan struct{}
+	// flushed is closed when writePump exits.
+	flushed chan struct{}
+
+	// holding and held are owned by the hub goroutine. While a resume
+	// replay is loading, messages for the client are queued in held.
+	holding bool
+	held    []*directMessage
+	// stale is owned by the hub goroutine; lastPong by the pumps.
+	stale    bool
+	lastPong atomic.Int64
+
+	// ip is the address counted against the per-IP connection limit.
+	ip          string
+	connectedAt time.Time
+	// closeStatus, once set by closeWith, is sent in the close frame after
+	// the queued messages. readPump stops reading once it is set.
+	closeStatus atomic.Pointer[[]byte]
+	// limiter, violations and violationsSince are owned by readPump.
+	limiter         messageLimiter
+	violations      int
+	violationsSince time.Time
+}
+
+var clientLog = newLogger("client")
+
+// logger returns clientLog annotated with the connection and player IDs.
+func (c *Client) logger() *slog.Logger {
+	return clientLog.With("client_id", c.ID, "player_id", c.PlayerID)
 }
 
 func serveWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	ip := clientIP(r, hub.config.RateLimit.TrustForwardedFor)
+	if !hub.limits.acquireConnection(ip) {
+		ipConnectionsRejected.Inc()
+		clientLog.Warn("too many connections from one address", "ip", ip)
+		http.Error(w, "too many connections", http.StatusTooManyRequests)
+		return
+	}
+	conn, err := hub.upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("[CLIENT] Error upgrading connection: %v", err)
+		hub.limits.releaseConnection(ip)
+		clientLog.Warn("error upgrading connection", "error", err)
 		return
 	}
 	client := &Client{
 		ID:   uuid.NewString(),
 		hub:  hub,
 		conn: conn,
-		send: make(chan []byte, 256),
+		send: make(chan []byte, hub.config.WebSocket.SendBuffer),
+
+		done:        make(chan struct{}),
+		flushed:     make(chan struct{}),
+		ip:          ip,
+		connectedAt: time.Now().UTC(),
+		limiter:     newMessageLimiter(hub.config.RateLimit),
 	}
-	log.Printf("[CLIENT] New WebSocket connection established with ID: %s", client.ID)
+	client.lastPong.Store(time.Now().UnixNano())
+	client.logger().Info("websocket connection established", "remote_addr", r.RemoteAddr)
 	client.hub.register <- client
 
 	go client.writePump()
@@ -46,74 +92,225 @@ func serveWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
 }
 
 func (c *Client) readPump() {
+	ws := c.hub.config.WebSocket
 	defer func() {
 		c.hub.unregister <- c
+		// Give writePump a moment to flush what is queued, such as the
+		// error explaining why the server is hanging up.
+		select {
+		case <-c.flushed:
+		case <-time.After(ws.WriteTimeout):
+		}
 		c.conn.Close()
+		c.hub.limits.releaseConnection(c.ip)
 	}()
+	c.conn.SetReadLimit(ws.MaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(ws.PongTimeout))
+	c.conn.SetPongHandler(func(string) error {
+		c.lastPong.Store(time.Now().UnixNano())
+		c.conn.SetReadDeadline(time.Now().Add(ws.PongTimeout))
+		return nil
+	})
 	for {
 		_, rawMessage, err := c.conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("[CLIENT] readPump error: %v", err)
+				c.logger().Warn("unexpected close reading from connection", "error", err)
 			}
 			break
 		}
-		log.Printf("[CLIENT] Received raw message from %s: %s", c.ID, string(rawMessage))
-
 		var msg Message
 		if err := json.Unmarshal(rawMessage, &msg); err != nil {
-			log.Printf("[CLIENT] Error unmarshalling message: %v", err)
+			c.logger().Warn("error unmarshalling message", "error", err, "bytes", len(rawMessage))
 			continue
 		}
 
-		log.Printf("[CLIENT] Parsed message type '%s' from client %s", msg.Type, c.ID)
-		switch msg.Type {
-		case "move":
-			handleMove(c, msg.Payload)
-		case "find_match":
-			handleFindMatch(c, msg.Payload)
-		case "get_leaderboard":
-			handleGetLeaderboard(c)
-		case "reconnect":
-			handleReconnect(c, msg.Payload)
-		default:
-			log.Printf("[CLIENT] Unknown message type received: %s", msg.Type)
+		c.logger().Debug("message received", "type", msg.Type, "bytes", len(rawMessage), "payload", string(rawMessage))
+		if c.closing() {
+			c.logger().Debug("connection closing, message ignored", "type", msg.Type)
+			break
+		}
+		ok, disconnect := c.allowMessage(msg.Type)
+		if disconnect {
+			rateLimitDisconnects.Inc()
+			c.logger().Warn("closing connection for repeated rate limit violations", "violations", c.violations)
+			c.closeWith(websocket.ClosePolicyViolation, "rate limit exceeded")
+			break
 		}
+		if ok {
+			c.dispatch(msg)
+		}
+		if c.closing() {
+			break
+		}
+	}
+}
+
+// dispatch runs the handler for one inbound message inside its own span.
+// Handlers pass the span's context to the store, so their Redis calls and
+// any messages they route to other replicas join the trace.
+func (c *Client) dispatch(msg Message) {
+	ctx, span := tracer.Start(context.Background(), "ws."+msg.Type,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("ws.message_type", msg.Type),
+			attribute.String("ws.client_id", c.ID),
+			attribute.String("player.id", c.PlayerID),
+		),
+	)
+	defer span.End()
+
+	switch msg.Type {
+	case "move":
+		handleMove(ctx, c, msg.Payload)
+	case "find_match":
+		handleFindMatch(ctx, c, msg.Payload)
+	case "get_leaderboard":
+		handleGetLeaderboard(ctx, c, msg.Payload)
+	case "reconnect":
+		handleReconnect(ctx, c, msg.Payload)
+	case "resume":
+		handleResume(ctx, c, msg.Payload)
+	case "create_tournament":
+		handleCreateTournament(ctx, c, msg.Payload)
+	case "join_tournament":
+		handleJoinTournament(ctx, c, msg.Payload)
+	case "start_tournament":
+		handleStartTournament(ctx, c, msg.Payload)
+	case "get_standings":
+		handleGetStandings(ctx, c, msg.Payload)
+	case "get_history":
+		handleGetHistory(ctx, c, msg.Payload)
+	case "get_game_events":
+		handleGetGameEvents(ctx, c, msg.Payload)
+	case "get_player_stats":
+		handleGetPlayerStats(ctx, c, msg.Payload)
+	default:
+		span.SetName("ws.unknown")
+		c.logger().Warn("unknown message type received", "type", msg.Type)
+	}
+}
+
+// closeWith records why the server is closing the connection. The first
+// reason recorded wins.
+func (c *Client) closeWith(code int, text string) {
+	status := websocket.FormatCloseMessage(code, text)
+	c.closeStatus.CompareAndSwap(nil, &status)
+}
+
+// closed reports whether the hub has dropped the connection.
+func (c *Client) closed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// closing reports whether the connection is being shut down, either by
+// the hub (a kick, ban or drain) or by readPump itself. Messages read after
+// that are not dispatched.
+func (c *Client) closing() bool {
+	return c.closeStatus.Load() != nil || c.closed()
+}
+
+// reply queues a response for the client. It blocks while the send buffer
+// is full, like a direct send, but returns false instead of blocking
+// forever once the hub has dropped the connection.
+func (c *Client) reply(message []byte) bool {
+	select {
+	case c.send <- message:
+		return true
+	case <-c.done:
+		return false
 	}
 }
 
 func (c *Client) writePump() {
+	ws := c.hub.config.WebSocket
+	ticker := time.NewTicker(ws.PingInterval)
 	defer func() {
+		ticker.Stop()
 		c.conn.Close()
+		close(c.flushed)
 	}()
+	var stale bool
+	var lastPing time.Time
 	for {
 		select {
-		case message, ok := <-c.send:
-			if !ok {
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
-				log.Printf("[CLIENT] writePump: Hub closed channel for client %s.", c.ID)
+		case message := <-c.send:
+			if !c.write(message) {
+				return
+			}
+
+		case <-c.done:
+			// Flush what the hub queued before dropping the connection,
+			// such as a server_shutdown or the error explaining a kick.
+		flush:
+			for {
+				select {
+				case message := <-c.send:
+					if !c.write(message) {
+						return
+					}
+				default:
+					break flush
+				}
+			}
+			var status []byte
+			if p := c.closeStatus.Load(); p != nil {
+				status = *p
+			}
+			c.conn.SetWriteDeadline(time.Now().Add(ws.WriteTimeout))
+			c.conn.WriteMessage(websocket.CloseMessage, status)
+			c.logger().Debug("hub dropped connection")
+			return
+
+		case <-ticker.C:
+			// No pong since the previous ping means it went unanswered for a
+			// whole interval.
+			silent := !lastPing.IsZero() && c.lastPong.Load() < lastPing.UnixNano()
+			if silent != stale {
+				stale = silent
+				c.hub.health <- &healthUpdate{client: c, stale: stale}
+			}
+			c.conn.SetWriteDeadline(time.Now().Add(ws.WriteTimeout))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				c.logger().Warn("ping to connection failed", "error", err)
 				return
 			}
-			log.Printf("[CLIENT] Sending message to client %s (PlayerID: %s): %s", c.ID, c.PlayerID, string(message))
-			c.conn.WriteMessage(websocket.TextMessage, message)
+			lastPing = time.Now()
 		}
 	}
 }
 
-func handleReconnect(client *Client, payload interface{}) {
-	log.Printf("[RECONNECT] Handling reconnect request...")
+func (c *Client) write(message []byte) bool {
+	c.conn.SetWriteDeadline(time.Now().Add(c.hub.config.WebSocket.WriteTimeout))
+	c.logger().Debug("sending message", "bytes", len(message), "payload", string(message))
+	if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+		c.logger().Warn("write to connection failed", "error", err)
+		return false
+	}
+	return true
+}
+
+func handleReconnect(ctx context.Context, client *Client, payload interface{}) {
 	var reconnectPayload ReconnectPayload
 	payloadData, _ := json.Marshal(payload)
 	json.Unmarshal(payloadData, &reconnectPayload)
 
-	client.PlayerID = reconnectPayload.PlayerID
+	if !client.identify(ctx, reconnectPayload.PlayerID) {
+		return
+	}
 	client.GameID = reconnectPayload.GameID
 
-	log.Printf("[RECONNECT] Player %s attempting to reconnect to game %s", client.PlayerID, client.GameID)
+	logger := client.logger().With("game_id", client.GameID)
+	logger.Info("reconnect requested")
 
 	game, err := getGame(ctx, client.GameID)
 	if err != nil || game == nil {
-		log.Printf("[RECONNECT] Failed: Game %s not found.", client.GameID)
+		logger.Warn("reconnect failed, game not found")
 		return
 	}
 
@@ -121,40 +318,46 @@ func handleReconnect(client *Client, payload interface{}) {
 		(game.PlayerO == client.PlayerID && game.Status == StatusDisconnectedO)
 
 	if isValidReconnect {
-		log.Printf("[RECONNECT] Player %s reconnected successfully to game %s.", client.PlayerID, client.GameID)
-		game.Status = StatusPlaying
-		saveGame(ctx, game)
-
-		response := Message{Type: "game_update", Payload: game}
-		responseJSON, _ := json.Marshal(response)
-		channel := "game:" + game.ID
-		rdb.Publish(ctx, channel, responseJSON)
+		if err := recordGameEvents(ctx, client.hub, game, GameEvent{Type: EventReconnect, PlayerID: client.PlayerID}); err != nil {
+			return
+		}
+		cancelDeadline(DeadlineForfeit, game.ID, client.PlayerID)
+		logger.Info("player reconnected")
+
+		publishGameUpdate(ctx, client.hub, game)
+		if reconnectPayload.LastEventID != "" {
+			sendGameEvents(ctx, client, game.ID, reconnectPayload.LastEventID)
+		}
 	} else {
-		log.Printf("[RECONNECT] Invalid reconnect attempt by Player %s for game %s with status %s.", client.PlayerID, client.GameID, game.Status)
+		logger.Warn("invalid reconnect attempt", "status", game.Status)
 	}
 }
 
-func handleMove(client *Client, payload interface{}) {
-	log.Printf("[MOVE] Handling move request from PlayerID: %s", client.PlayerID)
+func handleMove(ctx context.Context, client *Client, payload interface{}) {
+	start := time.Now()
+	defer func() { moveDuration.Observe(time.Since(start).Seconds()) }()
 	moveData, err := json.Marshal(payload)
 	if err != nil {
-		log.Printf("[MOVE] error marshalling move payload: %v", err)
+		client.logger().Warn("error marshalling move payload", "error", err)
 		return
 	}
 	var move MovePayload
 	if err := json.Unmarshal(moveData, &move); err != nil {
-		log.Printf("[MOVE] error unmarshalling move payload: %v", err)
+		client.logger().Warn("error unmarshalling move payload", "error", err)
 		return
 	}
+	logger := client.logger().With("game_id", move.GameID, "index", move.Index)
+	logger.Debug("move requested")
 
-	ctx := context.Background()
 	game, err := getGame(ctx, move.GameID)
-	if err != nil {
-		log.Printf("[MOVE] error getting game: %v", err)
+	if errors.Is(err, ErrNotFound) {
+		logger.Info("move rejected, game not found")
+		client.sendError(ErrorPayload{Code: "game_not_found", Message: "no such game", MessageType: "move"})
 		return
 	}
-	if game == nil {
-		log.Printf("[MOVE] game not found: %s", move.GameID)
+	if err != nil {
+		logger.Error("error loading game", "error", err)
+		client.sendError(ErrorPayload{Code: "internal_error", Message: "the move could not be processed, try again", MessageType: "move"})
 		return
 	}
 
@@ -164,68 +367,70 @@ func handleMove(client *Client, payload interface{}) {
 	} else if client.PlayerID == game.PlayerO {
 		currentPlayerSymbol = "O"
 	} else {
-		log.Printf("[MOVE] validation failed: client (PlayerID: %s) is not a player in game %s", client.PlayerID, game.ID)
+		logger.Warn("move rejected, client is not a player in the game")
 		return
 	}
 
 	if game.Status != StatusPlaying {
-		log.Printf("[MOVE] validation failed: game is already over (status: %s)", game.Status)
+		logger.Info("move rejected, game is over", "status", game.Status)
 		return
 	}
 	if game.Turn != currentPlayerSymbol {
-		log.Printf("[MOVE] validation failed: not player %s's turn", currentPlayerSymbol)
+		logger.Info("move rejected, not the player's turn", "symbol", currentPlayerSymbol)
 		return
 	}
 	if move.Index < 0 || move.Index > 8 || game.Board[move.Index] != "" {
-		log.Printf("[MOVE] validation failed: cell %d is invalid or not empty", move.Index)
+		logger.Info("move rejected, cell is invalid or taken")
 		return
 	}
 
-	game.applyMove(move.Index, currentPlayerSymbol)
-
-	if game.Status == StatusWinX {
-		updateLeaderboard(game.PlayerX)
-		rdb.SRem(ctx, inGameKey, game.PlayerX, game.PlayerO)
-	} else if game.Status == StatusWinO {
-		updateLeaderboard(game.PlayerO)
-		rdb.SRem(ctx, inGameKey, game.PlayerX, game.PlayerO)
-	} else if game.Status == StatusDraw {
-		rdb.SRem(ctx, inGameKey, game.PlayerX, game.PlayerO)
-	} else if game.Status == StatusPlaying {
-		if game.Turn == "X" {
-			game.Turn = "O"
-		} else {
-			game.Turn = "X"
-		}
+	events := []GameEvent{{Type: EventMove, PlayerID: client.PlayerID, Index: move.Index}}
+	preview := game.clone()
+	preview.apply(events[0])
+	if preview.Status != StatusPlaying {
+		events = append(events, GameEvent{Type: EventFinished, Status: preview.Status})
 	}
 
-	if err := saveGame(ctx, game); err != nil {
-		log.Printf("[MOVE] error saving game: %v", err)
+	if err := recordGameEvents(ctx, client.hub, game, events...); err != nil {
+		if errors.Is(err, ErrConflict) {
+			logger.Info("move rejected, game changed concurrently", "symbol", currentPlayerSymbol)
+		}
 		return
 	}
-	log.Printf("[MOVE] move successful on game %s by player %s", game.ID, currentPlayerSymbol)
+	logger.Info("move applied", "symbol", currentPlayerSymbol, "status", game.Status)
 
-	response := Message{
-		Type:    "game_update",
-		Payload: game,
-	}
-	responseJSON, err := json.Marshal(response)
-	if err != nil {
-		log.Printf("[MOVE] error marshalling game update: %v", err)
-		return
+	if err := publishGameUpdate(ctx, client.hub, game); err != nil {
+		logger.Error("error publishing game update", "error", err)
 	}
 
-	channel := "game:" + game.ID
-	if err := rdb.Publish(ctx, channel, responseJSON).Err(); err != nil {
-		log.Printf("[MOVE] error publishing game update: %v", err)
+	if game.Status != StatusPlaying {
+		finishGame(ctx, client.hub, game)
 	}
 }
 
-func handleGetLeaderboard(client *Client) {
-	log.Printf("[LEADERBOARD] Handling get_leaderboard request from PlayerID: %s", client.PlayerID)
-	scores, err := getLeaderboard()
+func handleGetLeaderboard(ctx context.Context, client *Client, payload interface{}) {
+	var leaderboardPayload LeaderboardPayload
+	if payload != nil {
+		payloadData, _ := json.Marshal(payload)
+		if err := json.Unmarshal(payloadData, &leaderboardPayload); err != nil {
+			client.logger().Warn("error unmarshalling get_leaderboard payload", "error", err)
+			return
+		}
+	}
+
+	playerID := client.PlayerID
+	if playerID == "" {
+		playerID = leaderboardPayload.PlayerID
+	}
+	scores, err := getLeaderboard(client.hub.config.Leaderboard, leaderboardPayload, playerID)
+	if errors.Is(err, errInvalidLeaderboard) {
+		client.logger().Info("get_leaderboard rejected", "error", err)
+		client.sendError(ErrorPayload{Code: "invalid_leaderboard", Message: err.Error(), MessageType: "get_leaderboard"})
+		return
+	}
 	if err != nil {
-		log.Printf("[LEADERBOARD] Error getting leaderboard: %v", err)
+		client.logger().Error("error loading leaderboard", "error", err)
+		client.sendError(ErrorPayload{Code: "internal_error", Message: "the leaderboard could not be loaded, try again", MessageType: "get_leaderboard"})
 		return
 	}
 
@@ -234,5 +439,5 @@ func handleGetLeaderboard(client *Client) {
 		Payload: scores,
 	}
 	responseJSON, _ := json.Marshal(response)
-	client.send <- responseJSON
+	client.reply(responseJSON)
 }
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every setting the server reads at startup. Values come from
+// defaultConfig, then an optional YAML file, then environment variables,
+// then command-line flags, each overriding the last.
+//
+// Each setting has a yaml key, an env variable and a flag named after its
+// yaml path ("game.forfeit_timeout" becomes -game.forfeit-timeout).
+type Config struct {
+	Port            string        `yaml:"port" env:"PORT" usage:"HTTP listen port"`
+	ReplicaID       string        `yaml:"replica_id" env:"REPLICA_ID" usage:"replica identity for presence and routing (random if empty)"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" usage:"how long SIGTERM waits for connections to flush"`
+
+	TLS         TLSConfig         `yaml:"tls"`
+	Store       StoreConfig       `yaml:"store"`
+	WebSocket   WebSocketConfig   `yaml:"websocket"`
+	Origins     OriginsConfig     `yaml:"origins"`
+	RateLimit   RateLimitConfig   `yaml:"rate_limit"`
+	Matchmaking MatchmakingConfig `yaml:"matchmaking"`
+	Game        GameConfig        `yaml:"game"`
+	Leaderboard LeaderboardConfig `yaml:"leaderboard"`
+	Archive     ArchiveConfig     `yaml:"archive"`
+	Reaper      ReaperConfig      `yaml:"reaper"`
+	Tournament  TournamentConfig  `yaml:"tournament"`
+	Logging     LoggingConfig     `yaml:"logging"`
+	Tracing     TracingConfig     `yaml:"tracing"`
+	Admin       AdminConfig       `yaml:"admin"`
+	Maintenance MaintenanceConfig `yaml:"maintenance"`
+}
+
+// TLSConfig turns on HTTPS when CertFile and KeyFile are set.
+type TLSConfig struct {
+	CertFile       string        `yaml:"cert_file" env:"TLS_CERT_FILE" usage:"PEM certificate chain; serve HTTPS when set with key_file"`
+	KeyFile        string        `yaml:"key_file" env:"TLS_KEY_FILE" usage:"PEM private key for cert_file"`
+	ReloadInterval time.Duration `yaml:"reload_interval" env:"TLS_RELOAD_INTERVAL" usage:"how often the certificate files are checked for changes"`
+	RedirectPort   string        `yaml:"redirect_port" env:"TLS_REDIRECT_PORT" usage:"plain HTTP port redirecting to HTTPS (empty disables)"`
+}
+
+func (c TLSConfig) enabled() bool {
+	return c.CertFile != ""
+}
+
+type StoreConfig struct {
+	Backend  string `yaml:"backend" env:"STORE" usage:"state backend: redis or memory"`
+	RedisURL string `yaml:"redis_url" env:"REDIS_URL" usage:"Redis connection URL"`
+}
+
+type WebSocketConfig struct {
+	ReadBufferSize  int           `yaml:"read_buffer_size" env:"WS_READ_BUFFER_SIZE" usage:"socket read buffer in bytes"`
+	WriteBufferSize int           `yaml:"write_buffer_size" env:"WS_WRITE_BUFFER_SIZE" usage:"socket write buffer in bytes"`
+	SendBuffer      int           `yaml:"send_buffer" env:"WS_SEND_BUFFER" usage:"outbound messages queued per connection before it is dropped"`
+	PingInterval    time.Duration `yaml:"ping_interval" env:"WS_PING_INTERVAL" usage:"how often connections are pinged"`
+	PongTimeout     time.Duration `yaml:"pong_timeout" env:"WS_PONG_TIMEOUT" usage:"how long a silent connection stays open"`
+	WriteTimeout    time.Duration `yaml:"write_timeout" env:"WS_WRITE_TIMEOUT" usage:"deadline for each socket write"`
+	MaxMessageSize  int64         `yaml:"max_message_size" env:"WS_MAX_MESSAGE_SIZE" usage:"largest inbound message in bytes"`
+}
+
+type OriginsConfig struct {
+	Allowed []string `yaml:"allowed" env:"ALLOWED_ORIGINS" usage:"comma-separated browser origins allowed for WebSocket and HTTP requests (none by default; * for any, https://*.example.com for subdomains)"`
+}
+
+// RateLimitConfig sets a token bucket per message class: Burst messages at
+// once, then one more every Interval. An Interval of 0 leaves the class
+// unlimited.
+type RateLimitConfig struct {
+	MoveBurst           int           `yaml:"move_burst" env:"RATE_LIMIT_MOVE_BURST" usage:"move messages allowed at once"`
+	MoveInterval        time.Duration `yaml:"move_interval" env:"RATE_LIMIT_MOVE_INTERVAL" usage:"time to earn another move message (0 disables)"`
+	FindMatchBurst      int           `yaml:"find_match_burst" env:"RATE_LIMIT_FIND_MATCH_BURST" usage:"find_match messages allowed at once"`
+	FindMatchInterval   time.Duration `yaml:"find_match_interval" env:"RATE_LIMIT_FIND_MATCH_INTERVAL" usage:"time to earn another find_match message (0 disables)"`
+	LeaderboardBurst    int           `yaml:"leaderboard_burst" env:"RATE_LIMIT_LEADERBOARD_BURST" usage:"get_leaderboard messages allowed at once"`
+	LeaderboardInterval time.Duration `yaml:"leaderboard_interval" env:"RATE_LIMIT_LEADERBOARD_INTERVAL" usage:"time to earn another get_leaderboard message (0 disables)"`
+	DefaultBurst        int           `yaml:"default_burst" env:"RATE_LIMIT_DEFAULT_BURST" usage:"other messages allowed at once"`
+	DefaultInterval     time.Duration `yaml:"default_interval" env:"RATE_LIMIT_DEFAULT_INTERVAL" usage:"time to earn another of any other message (0 disables)"`
+
+	MaxViolations   int           `yaml:"max_violations" env:"RATE_LIMIT_MAX_VIOLATIONS" usage:"rate-limited messages within violation_window before disconnecting (0 never disconnects)"`
+	ViolationWindow time.Duration `yaml:"violation_window" env:"RATE_LIMIT_VIOLATION_WINDOW" usage:"window for counting rate-limit violations"`
+
+	MaxConnectionsPerIP int  `yaml:"max_connections_per_ip" env:"MAX_CONNECTIONS_PER_IP" usage:"WebSocket connections allowed per client IP (0 for unlimited)"`
+	TrustForwardedFor   bool `yaml:"trust_forwarded_for" env:"TRUST_FORWARDED_FOR" usage:"take the client IP from X-Forwarded-For (only behind a trusted proxy)"`
+}
+
+type MatchmakingConfig struct {
+	Interval time.Duration `yaml:"interval" env:"MATCHMAKING_INTERVAL" usage:"how often queued players are paired"`
+}
+
+type GameConfig struct {
+	ForfeitTimeout time.Duration `yaml:"forfeit_timeout" env:"FORFEIT_TIMEOUT" usage:"how long a disconnected player has to reconnect"`
+	MoveTimeout    time.Duration `yaml:"move_timeout" env:"MOVE_TIMEOUT" usage:"per-move time limit (0 disables the move clock)"`
+	UpdateBuffer   int64         `yaml:"update_buffer" env:"GAME_UPDATE_BUFFER" usage:"recent game updates kept per game for resume"`
+	FinishedTTL    time.Duration `yaml:"finished_ttl" env:"FINISHED_GAME_TTL" usage:"how long finished games stay in the store"`
+}
+
+type LeaderboardConfig struct {
+	Size            int64         `yaml:"size" env:"LEADERBOARD_SIZE" usage:"entries returned when a request gives no limit"`
+	MaxLimit        int64         `yaml:"max_limit" env:"LEADERBOARD_MAX_LIMIT" usage:"largest page a request may ask for"`
+	WinRateMinGames int64         `yaml:"win_rate_min_games" env:"WIN_RATE_MIN_GAMES" usage:"games needed to appear on the win-rate leaderboard"`
+	SeasonLength    time.Duration `yaml:"season_length" env:"SEASON_LENGTH" usage:"length of a ranked season"`
+	SeasonEpoch     time.Time     `yaml:"season_epoch" env:"SEASON_EPOCH" usage:"start of season 1 (RFC 3339)"`
+}
+
+type ArchiveConfig struct {
+	Driver string `yaml:"driver" env:"ARCHIVE_DRIVER" usage:"finished game archive: sqlite, postgres or none"`
+	DSN    string `yaml:"dsn" env:"ARCHIVE_DSN" usage:"archive data source name (sqlite defaults to games.db)"`
+}
+
+type ReaperConfig struct {
+	Interval time.Duration `yaml:"interval" env:"REAPER_INTERVAL" usage:"how often the stale-state reaper runs"`
+	Grace    time.Duration `yaml:"grace" env:"REAPER_GRACE" usage:"how long a live game must be idle before the reaper resolves it"`
+}
+
+type TournamentConfig struct {
+	ExportDir string `yaml:"export_dir" env:"TOURNAMENT_EXPORT_DIR" usage:"directory for final tournament standings files"`
+}
+
+type LoggingConfig struct {
+	Level            string `yaml:"level" env:"LOG_LEVEL" usage:"minimum log level: debug, info, warn or error"`
+	SampleInitial    int    `yaml:"sample_initial" env:"LOG_SAMPLE_INITIAL" usage:"identical records written per second before sampling (0 disables sampling)"`
+	SampleThereafter int    `yaml:"sample_thereafter" env:"LOG_SAMPLE_THEREAFTER" usage:"after that, write one in this many"`
+	Redact           bool   `yaml:"redact" env:"LOG_REDACT" usage:"redact payloads and player names"`
+}
+
+// AdminConfig protects the /admin API. With no token the API is not served.
+type AdminConfig struct {
+	Token string `yaml:"token" env:"ADMIN_TOKEN" usage:"bearer token for the /admin API (empty disables it)"`
+}
+
+// MaintenanceConfig controls how quickly a replica notices maintenance
+// mode being switched on or off by another replica or directly in Redis.
+type MaintenanceConfig struct {
+	PollInterval time.Duration `yaml:"poll_interval" env:"MAINTENANCE_POLL_INTERVAL" usage:"how often the shared maintenance flag is checked"`
+}
+
+type TracingConfig struct {
+	Exporter string `yaml:"exporter" env:"OTEL_TRACES_EXPORTER" usage:"span exporter: otlp, stdout or none"`
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Port:            "8080",
+		ShutdownTimeout: 10 * time.Second,
+		TLS:             TLSConfig{ReloadInterval: 30 * time.Second},
+		Store: StoreConfig{
+			Backend:  "redis",
+			RedisURL: "redis://localhost:6379",
+		},
+		WebSocket: WebSocketConfig{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+			SendBuffer:      256,
+			PingInterval:    20 * time.Second,
+			PongTimeout:     45 * time.Second,
+			WriteTimeout:    10 * time.Second,
+			MaxMessageSize:  4096,
+		},
+		// No browser origin is trusted until the operator lists it.
+		Origins: OriginsConfig{Allowed: []string{}},
+		RateLimit: RateLimitConfig{
+			MoveBurst:           5,
+			MoveInterval:        100 * time.Millisecond,
+			FindMatchBurst:      3,
+			FindMatchInterval:   2 * time.Second,
+			LeaderboardBurst:    5,
+			LeaderboardInterval: time.Second,
+			DefaultBurst:        20,
+			DefaultInterval:     100 * time.Millisecond,
+			MaxViolations:       20,
+			ViolationWindow:     10 * time.Second,
+			MaxConnectionsPerIP: 20,
+		},
+		Matchmaking: MatchmakingConfig{Interval: 3 * time.Second},
+		Game: GameConfig{
+			ForfeitTimeout: 30 * time.Second,
+			UpdateBuffer:   50,
+			FinishedTTL:    time.Hour,
+		},
+		Leaderboard: LeaderboardConfig{
+			Size:            10,
+			MaxLimit:        100,
+			WinRateMinGames: 10,
+			SeasonLength:    30 * 24 * time.Hour,
+			SeasonEpoch:     time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC),
+		},
+		Archive: ArchiveConfig{Driver: "sqlite"},
+		Reaper: ReaperConfig{
+			Interval: time.Minute,
+			Grace:    2 * time.Minute,
+		},
+		Maintenance: MaintenanceConfig{PollInterval: 2 * time.Second},
+		Logging: LoggingConfig{
+			Level:            "info",
+			SampleInitial:    100,
+			SampleThereafter: 100,
+			Redact:           true,
+		},
+		Tracing: TracingConfig{Exporter: "none"},
+	}
+}
+
+// configField is one setting found by walking Config.
+type configField struct {
+	path  string
+	env   string
+	usage string
+	value reflect.Value
+}
+
+func (f configField) flagName() string {
+	return strings.ReplaceAll(f.path, "_", "-")
+}
+
+func configFields(v reflect.Value, prefix string) []configField {
+	var fields []configField
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		path := prefix + sf.Tag.Get("yaml")
+		if env := sf.Tag.Get("env"); env != "" {
+			fields = append(fields, configField{path: path, env: env, usage: sf.Tag.Get("usage"), value: v.Field(i)})
+			continue
+		}
+		if sf.Type.Kind() == reflect.Struct {
+			fields = append(fields, configFields(v.Field(i), path+".")...)
+		}
+	}
+	return fields
+}
+
+// setConfigValue parses raw into v, which must be one of the field types
+// used by Config.
+func setConfigValue(v reflect.Value, raw string) error {
+	switch v.Interface().(type) {
+	case time.Duration:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case time.Time:
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(t.UTC()))
+	case []string:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	case string:
+		v.SetString(raw)
+	case bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case int, int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+	return nil
+}
+
+// loadConfig builds the configuration from args (normally os.Args[1:]), the
+// environment and the YAML file named by -config or CONFIG_FILE.
+func loadConfig(args []string) (*Config, error) {
+	cfg := defaultConfig()
+	fields := configFields(reflect.ValueOf(cfg).Elem(), "")
+
+	// Flags are parsed first to find the config file, but applied last so
+	// they override it and the environment.
+	fs := flag.NewFlagSet("tic-tac-toe-be", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML config file (env CONFIG_FILE)")
+	flagged := make(map[string]string)
+	for _, field := range fields {
+		field := field
+		fs.Func(field.flagName(), field.usage+" (env "+field.env+")", func(raw string) error {
+			if err := setConfigValue(reflect.New(field.value.Type()).Elem(), raw); err != nil {
+				return err
+			}
+			flagged[field.path] = raw
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *configPath != "" {
+		data, err := os.ReadFile(*configPath)
+		if err != nil {
+			return nil, err
+		}
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%s: %w", *configPath, err)
+		}
+	}
+
+	for _, field := range fields {
+		raw := os.Getenv(field.env)
+		if raw == "" {
+			continue
+		}
+		if err := setConfigValue(field.value, raw); err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %w", field.env, raw, err)
+		}
+	}
+	for _, field := range fields {
+		if raw, ok := flagged[field.path]; ok {
+			setConfigValue(field.value, raw)
+		}
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// validate reports every invalid setting at once.
+func (c *Config) validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Port != "", "port must be set")
+	check(c.ShutdownTimeout > 0, "shutdown_timeout must be positive")
+	check((c.TLS.CertFile == "") == (c.TLS.KeyFile == ""), "tls.cert_file and tls.key_file must be set together")
+	check(c.TLS.RedirectPort == "" || c.TLS.enabled(), "tls.redirect_port requires tls.cert_file and tls.key_file")
+	check(c.TLS.RedirectPort != c.Port, "tls.redirect_port must differ from port")
+	check(c.TLS.ReloadInterval > 0, "tls.reload_interval must be positive")
+	check(c.Store.Backend == "redis" || c.Store.Backend == "memory", "store.backend must be redis or memory, got %q", c.Store.Backend)
+
+	ws := c.WebSocket
+	check(ws.ReadBufferSize > 0 && ws.WriteBufferSize > 0, "websocket buffer sizes must be positive")
+	check(ws.SendBuffer > 0, "websocket.send_buffer must be positive")
+	check(ws.PingInterval > 0 && ws.WriteTimeout > 0, "websocket.ping_interval and websocket.write_timeout must be positive")
+	check(ws.PongTimeout > ws.PingInterval, "websocket.pong_timeout (%s) must be longer than websocket.ping_interval (%s)", ws.PongTimeout, ws.PingInterval)
+	check(ws.MaxMessageSize > 0, "websocket.max_message_size must be positive")
+
+	_, err := newOriginPolicy(c.Origins.Allowed)
+	check(err == nil, "origins.allowed: %v", err)
+	rl := c.RateLimit
+	check(rl.MoveBurst > 0 && rl.FindMatchBurst > 0 && rl.LeaderboardBurst > 0 && rl.DefaultBurst > 0, "rate_limit bursts must be positive")
+	check(rl.MoveInterval >= 0 && rl.FindMatchInterval >= 0 && rl.LeaderboardInterval >= 0 && rl.DefaultInterval >= 0, "rate_limit intervals must not be negative")
+	check(rl.MaxViolations >= 0 && rl.MaxConnectionsPerIP >= 0, "rate_limit.max_violations and rate_limit.max_connections_per_ip must not be negative")
+	check(rl.MaxViolations == 0 || rl.ViolationWindow > 0, "rate_limit.violation_window must be positive")
+
+	check(c.Matchmaking.Interval > 0, "matchmaking.interval must be positive")
+
+	check(c.Game.ForfeitTimeout > 0, "game.forfeit_timeout must be positive")
+	check(c.Game.MoveTimeout >= 0, "game.move_timeout must not be negative")
+	check(c.Game.UpdateBuffer > 0, "game.update_buffer must be positive")
+	check(c.Game.FinishedTTL > 0, "game.finished_ttl must be positive")
+
+	lb := c.Leaderboard
+	check(lb.Size > 0 && lb.Size <= lb.MaxLimit, "leaderboard.size must be between 1 and leaderboard.max_limit (%d)", lb.MaxLimit)
+	check(lb.WinRateMinGames > 0, "leaderboard.win_rate_min_games must be positive")
+	check(lb.SeasonLength > 0, "leaderboard.season_length must be positive")
+
+	switch c.Archive.Driver {
+	case "sqlite", "none":
+	case "postgres":
+		check(c.Archive.DSN != "", "archive.dsn is required for the postgres archive")
+	default:
+		check(false, "archive.driver must be sqlite, postgres or none, got %q", c.Archive.Driver)
+	}
+
+	check(c.Admin.Token == "" || len(c.Admin.Token) >= 16, "admin.token must be at least 16 characters")
+	check(c.Reaper.Interval > 0 && c.Reaper.Grace > 0, "reaper.interval and reaper.grace must be positive")
+	check(c.Maintenance.PollInterval > 0, "maintenance.poll_interval must be positive")
+
+	var level slog.Level
+	check(level.UnmarshalText([]byte(c.Logging.Level)) == nil, "logging.level must be debug, info, warn or error, got %q", c.Logging.Level)
+	check(c.Logging.SampleInitial >= 0, "logging.sample_initial must not be negative")
+	check(c.Logging.SampleThereafter > 0, "logging.sample_thereafter must be positive")
+
+	switch c.Tracing.Exporter {
+	case "otlp", "stdout", "none":
+	default:
+		check(false, "tracing.exporter must be otlp, stdout or none, got %q", c.Tracing.Exporter)
+	}
+	return errors.Join(errs...)
+}
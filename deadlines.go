@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	DeadlineForfeit   = "forfeit"
+	DeadlineMoveClock = "move_clock"
+	DeadlineArchive   = "archive"
+)
+
+const deadlineLockName = "deadlines"
+const deadlinePollInterval = time.Second
+const deadlineBatchSize = 100
+
+// deadlineRetryDelay is how long a deadline whose handler failed waits
+// before it is tried again.
+const deadlineRetryDelay = 5 * time.Second
+
+var deadlineLog = newLogger("deadlines")
+
+// deadlineHandler runs a due deadline. args are the arguments it was
+// scheduled with. An error keeps the deadline so it is retried, so
+// handlers must tolerate running after the situation they guard has
+// resolved, and running twice.
+type deadlineHandler struct {
+	args int
+	run  func(hub *Hub, args []string) error
+}
+
+var deadlineHandlers = map[string]deadlineHandler{
+	DeadlineForfeit:   {args: 2, run: handleForfeitDeadline},
+	DeadlineMoveClock: {args: 2, run: handleMoveClockDeadline},
+	DeadlineArchive:   {args: 1, run: handleArchiveDeadline},
+}
+
+// deadlineMember joins the kind and arguments with colons. Only the last
+// argument may itself contain a colon, which is why player IDs, which
+// clients choose, always come last.
+func deadlineMember(kind string, args ...string) string {
+	return strings.Join(append([]string{kind}, args...), ":")
+}
+
+// parseDeadlineMember splits a member back into its kind and arguments.
+func parseDeadlineMember(member string) (deadlineHandler, []string, bool) {
+	kind, rest, _ := strings.Cut(member, ":")
+	handler, ok := deadlineHandlers[kind]
+	if !ok {
+		return handler, nil, false
+	}
+	args := strings.SplitN(rest, ":", handler.args)
+	return handler, args, len(args) == handler.args
+}
+
+func scheduleDeadline(kind string, due time.Time, args ...string) error {
+	member := deadlineMember(kind, args...)
+	if err := store.ScheduleDeadline(ctx, member, due); err != nil {
+		deadlineLog.Error("error scheduling deadline", "deadline", member, "error", err)
+		return err
+	}
+	return nil
+}
+
+func cancelDeadline(kind string, args ...string) {
+	member := deadlineMember(kind, args...)
+	if err := store.CancelDeadline(ctx, member); err != nil {
+		deadlineLog.Error("error cancelling deadline", "deadline", member, "error", err)
+	}
+}
+
+// startDeadlineScheduler polls for due deadlines until shutdown is
+// cancelled. Every replica polls; the lock makes sure only one of them
+// handles a batch at a time. A deadline is removed only once its handler
+// succeeds: one whose handler fails is rescheduled deadlineRetryDelay
+// later, and one claimed by a replica that dies is picked up again once
+// the lock expires.
+func startDeadlineScheduler(shutdown context.Context, hub *Hub) {
+	deadlineLog.Info("deadline scheduler started")
+	ticker := time.NewTicker(deadlinePollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-shutdown.Done():
+			deadlineLog.Info("deadline scheduler stopped")
+			return
+		case <-ticker.C:
+		}
+		runDueDeadlines(hub)
+	}
+}
+
+func runDueDeadlines(hub *Hub) {
+	token, err := store.AcquireLock(ctx, deadlineLockName, 30*time.Second)
+	if err != nil || token == "" {
+		return
+	}
+	defer store.ReleaseLock(ctx, deadlineLockName, token)
+
+	due, err := store.DueDeadlines(ctx, time.Now(), deadlineBatchSize)
+	if err != nil {
+		deadlineLog.Error("error loading due deadlines", "error", err)
+		return
+	}
+	for _, member := range due {
+		if handler, args, ok := parseDeadlineMember(member); ok {
+			deadlineLog.Info("running deadline", "deadline", member)
+			if err := handler.run(hub, args); err != nil {
+				deadlineLog.Warn("deadline failed, retrying later", "deadline", member, "retry_in", deadlineRetryDelay.String(), "error", err)
+				if err := store.ScheduleDeadline(ctx, member, time.Now().Add(deadlineRetryDelay)); err != nil {
+					deadlineLog.Error("error rescheduling deadline", "deadline", member, "error", err)
+				}
+				continue
+			}
+		} else {
+			deadlineLog.Warn("malformed deadline or no handler, dropping it", "deadline", member)
+		}
+		if err := store.CancelDeadline(ctx, member); err != nil {
+			deadlineLog.Error("error removing deadline", "deadline", member, "error", err)
+		}
+	}
+}
+
+// handleForfeitDeadline awards the game to the opponent if the player is
+// still disconnected. args are the game ID and the player ID.
+func handleForfeitDeadline(hub *Hub, args []string) error {
+	gameID, playerID := args[0], args[1]
+	game, err := getGame(ctx, gameID)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	disconnected := (game.Status == StatusDisconnectedX && game.PlayerX == playerID) ||
+		(game.Status == StatusDisconnectedO && game.PlayerO == playerID)
+	if !disconnected {
+		gameLog.Info("forfeit timer ended, player already reconnected", "player_id", playerID, "game_id", gameID)
+		return nil
+	}
+
+	gameLog.Info("forfeit timer ended, game forfeited", "player_id", playerID, "game_id", gameID)
+	forfeit := GameEvent{Type: EventForfeit, PlayerID: playerID}
+	finished := GameEvent{Type: EventFinished}
+	if err := recordGameEvents(ctx, hub, game, forfeit, finished); err != nil {
+		return err
+	}
+	publishGameUpdate(ctx, hub, game)
+	finishGame(ctx, hub, game)
+	return nil
+}
+
+// handleMoveClockDeadline ends the game on time if nobody has moved since
+// the clock was started. args are the game ID and the event ID the clock
+// was started at.
+func handleMoveClockDeadline(hub *Hub, args []string) error {
+	gameID, eventID := args[0], args[1]
+	game, err := getGame(ctx, gameID)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if game.Status != StatusPlaying || game.EventID != eventID {
+		return nil
+	}
+
+	playerID := game.PlayerX
+	if game.Turn == "O" {
+		playerID = game.PlayerO
+	}
+	gameLog.Info("player ran out of time", "player_id", playerID, "game_id", gameID)
+	timeout := GameEvent{Type: EventTimeout, PlayerID: playerID}
+	finished := GameEvent{Type: EventFinished}
+	if err := recordGameEvents(ctx, hub, game, timeout, finished); err != nil {
+		return err
+	}
+	publishGameUpdate(ctx, hub, game)
+	finishGame(ctx, hub, game)
+	return nil
+}
+
+// restartMoveClock replaces the game's move clock after its state moved
+// from previousEventID to game.EventID. A zero moveTimeout disables it.
+func restartMoveClock(game *Game, previousEventID string, moveTimeout time.Duration) {
+	if moveTimeout <= 0 {
+		return
+	}
+	if previousEventID != "" {
+		cancelDeadline(DeadlineMoveClock, game.ID, previousEventID)
+	}
+	if game.Status == StatusPlaying {
+		scheduleDeadline(DeadlineMoveClock, time.Now().Add(moveTimeout), game.ID, game.EventID)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+)
+
+var deliveryLog = newLogger("delivery")
+
+// publishGameUpdate stamps the game's state with its next sequence number,
+// buffers it for resume and routes it to the replicas holding the players.
+// The buffer keeps the last Game.UpdateBuffer updates of each game.
+func publishGameUpdate(ctx context.Context, hub *Hub, game *Game) error {
+	seq, err := store.NextGameUpdateSeq(ctx, game.ID)
+	if err != nil {
+		deliveryLog.Error("error reserving update sequence number", "game_id", game.ID, "error", err)
+		return err
+	}
+	message, err := json.Marshal(Message{Type: "game_update", Seq: seq, Payload: game})
+	if err != nil {
+		deliveryLog.Error("error marshalling game update", "game_id", game.ID, "error", err)
+		return err
+	}
+	if err := store.BufferGameUpdate(ctx, game.ID, GameUpdate{Seq: seq, Message: message}, hub.config.Game.UpdateBuffer); err != nil {
+		deliveryLog.Error("error buffering game update", "game_id", game.ID, "seq", seq, "error", err)
+		return err
+	}
+	for _, playerID := range []string{game.PlayerX, game.PlayerO} {
+		routeDirect(ctx, &directMessage{playerID: playerID, message: message, gameID: game.ID, seq: seq})
+	}
+	return nil
+}
+
+// handleResume replays the game updates a client missed after lastSeq and
+// then lets live updates through again. Live updates that arrive while the
+// replay is being loaded are held by the hub so nothing is delivered out of
+// order. If the buffer cannot fill the gap after lastSeq, because it was
+// trimmed, expired or never held the updates, the client gets the current
+// state instead.
+func handleResume(ctx context.Context, client *Client, payload interface{}) {
+	payloadData, _ := json.Marshal(payload)
+	var resume ResumePayload
+	if err := json.Unmarshal(payloadData, &resume); err != nil {
+		client.logger().Warn("error unmarshalling resume payload", "error", err)
+		return
+	}
+
+	// Read before the game so a snapshot never carries a sequence number
+	// newer than its state.
+	currentSeq, err := store.GameUpdateSeq(ctx, resume.GameID)
+	if err != nil {
+		client.logger().Error("error loading update sequence number", "game_id", resume.GameID, "error", err)
+		client.sendError(ErrorPayload{Code: "internal_error", Message: "the game could not be resumed, try again", MessageType: "resume"})
+		return
+	}
+	game, err := getGame(ctx, resume.GameID)
+	if errors.Is(err, ErrNotFound) {
+		client.logger().Info("resume rejected, game not found", "game_id", resume.GameID)
+		client.sendError(ErrorPayload{Code: "game_not_found", Message: "no such game", MessageType: "resume"})
+		return
+	}
+	if err != nil {
+		client.logger().Error("error loading game to resume", "game_id", resume.GameID, "error", err)
+		client.sendError(ErrorPayload{Code: "internal_error", Message: "the game could not be resumed, try again", MessageType: "resume"})
+		return
+	}
+	if resume.PlayerID != game.PlayerX && resume.PlayerID != game.PlayerO {
+		client.logger().Warn("resume rejected, player is not in the game", "game_id", game.ID)
+		return
+	}
+
+	if !client.identifyHolding(ctx, resume.PlayerID) {
+		return
+	}
+	client.GameID = game.ID
+
+	replay := &replayRequest{client: client, gameID: game.ID}
+	defer func() { client.hub.replay <- replay }()
+
+	updates, err := store.GameUpdatesSince(ctx, game.ID, resume.LastSeq)
+	if err != nil {
+		client.logger().Error("error loading buffered updates", "game_id", game.ID, "error", err)
+		return
+	}
+	if currentSeq > resume.LastSeq && (len(updates) == 0 || updates[0].Seq > resume.LastSeq+1) {
+		client.logger().Info("resume gap, sending current state", "game_id", game.ID, "current_seq", currentSeq, "last_seq", resume.LastSeq)
+		message, _ := json.Marshal(Message{Type: "game_update", Seq: currentSeq, Payload: game})
+		snapshot := []GameUpdate{{Seq: currentSeq, Message: message}}
+		// Updates published since the sequence number was read follow it.
+		for _, update := range updates {
+			if update.Seq > currentSeq {
+				snapshot = append(snapshot, update)
+			}
+		}
+		updates = snapshot
+	}
+	replay.updates = updates
+	client.logger().Info("replaying game updates", "game_id", game.ID, "updates", len(updates), "last_seq", resume.LastSeq)
+}
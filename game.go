@@ -2,12 +2,8 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
-	"log"
+	"errors"
 	"time"
-
-	"github.com/go-redis/redis/v8"
 )
 
 const (
@@ -17,8 +13,21 @@ const (
 	StatusDraw          = "draw"
 	StatusDisconnectedX = "disconnected_x"
 	StatusDisconnectedO = "disconnected_o"
+	// StatusAbandoned ends a game both players left. Nobody wins.
+	StatusAbandoned = "abandoned"
+	// StatusVoid ends a game an operator cancelled. It counts for nobody
+	// and is left out of player records.
+	StatusVoid = "void"
 )
 
+const VariantClassic = "classic"
+
+type Move struct {
+	Index    int       `json:"index"`
+	Player   string    `json:"player"`
+	PlayedAt time.Time `json:"playedAt"`
+}
+
 type Game struct {
 	ID          string    `json:"id"`
 	PlayerX     string    `json:"playerX"`
@@ -28,6 +37,17 @@ type Game struct {
 	Board       [9]string `json:"board"`
 	Turn        string    `json:"turn"`
 	Status      string    `json:"status"`
+
+	Variant    string     `json:"variant,omitempty"`
+	Moves      []Move     `json:"moves"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+
+	TournamentID string `json:"tournamentId,omitempty"`
+	Round        int    `json:"round,omitempty"`
+
+	// EventID is the ID of the last event folded into this state.
+	EventID string `json:"eventId,omitempty"`
 }
 
 var winningCombinations = [][3]int{
@@ -36,58 +56,71 @@ var winningCombinations = [][3]int{
 	{0, 4, 8}, {2, 4, 6},
 }
 
-func handleGameDisconnect(playerID string, gameID string) {
-	log.Printf("[GAME] Player %s disconnected. Starting 30s forfeit timer for game %s.", playerID, gameID)
+var gameLog = newLogger("game")
+
+// handleGameDisconnect marks the player disconnected and schedules the
+// forfeit. The deadline is persisted, so it fires even if this replica
+// restarts in the meantime. It reports whether the forfeit timer was
+// started; there is none for a player still online or a game that is over.
+func handleGameDisconnect(hub *Hub, playerID string, gameID string) (bool, error) {
+	if isOnline(ctx, playerID) {
+		gameLog.Info("player still connected on another replica, no forfeit timer", "player_id", playerID, "game_id", gameID)
+		return false, nil
+	}
 	game, err := getGame(ctx, gameID)
-	if err != nil || game == nil || game.Status != StatusPlaying {
-		log.Printf("[GAME] Forfeit timer cancelled for game %s: game already over or not found.", gameID)
-		return
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return false, err
+	}
+	if err != nil || game.Status != StatusPlaying {
+		gameLog.Info("no forfeit timer, game over or not found", "player_id", playerID, "game_id", gameID)
+		return false, nil
 	}
+	forfeitTimeout := hub.config.Game.ForfeitTimeout
+	gameLog.Info("player disconnected, starting forfeit timer", "player_id", playerID, "game_id", gameID, "timeout", forfeitTimeout.String())
 
-	var disconnectedPlayerSymbol string
-	if playerID == game.PlayerX {
-		disconnectedPlayerSymbol = "X"
-		game.Status = StatusDisconnectedX
-	} else {
-		disconnectedPlayerSymbol = "O"
-		game.Status = StatusDisconnectedO
+	if err := recordGameEvents(ctx, hub, game, GameEvent{Type: EventDisconnect, PlayerID: playerID}); err != nil {
+		return false, err
+	}
+	if err := scheduleDeadline(DeadlineForfeit, time.Now().Add(forfeitTimeout), gameID, playerID); err != nil {
+		return false, err
 	}
-	saveGame(ctx, game)
 
-	response := Message{Type: "game_update", Payload: game}
-	responseJSON, _ := json.Marshal(response)
-	channel := "game:" + game.ID
-	rdb.Publish(ctx, channel, responseJSON)
+	publishGameUpdate(ctx, hub, game)
+	return true, nil
+}
 
-	time.Sleep(30 * time.Second)
+// finishGame runs the bookkeeping shared by every path that ends a game:
+// leaderboard credit, player stats, releasing both players, tournament
+// results and archiving.
+func finishGame(ctx context.Context, hub *Hub, game *Game) {
+	gamesFinished.WithLabelValues(game.Status).Inc()
+	switch game.Status {
+	case StatusWinX:
+		updateLeaderboard(hub.config.Leaderboard, game.PlayerX)
+	case StatusWinO:
+		updateLeaderboard(hub.config.Leaderboard, game.PlayerO)
+	}
+	recordGameStats(hub.config.Leaderboard, game)
+	store.ClearInGame(ctx, game.PlayerX, game.PlayerO)
 
-	game, err = getGame(ctx, gameID)
-	if err != nil || game == nil {
-		return
+	if game.TournamentID != "" {
+		recordTournamentResult(hub, game)
 	}
+	archiveFinishedGame(game, hub.config.Game.FinishedTTL)
+}
 
-	if game.Status == StatusDisconnectedX || game.Status == StatusDisconnectedO {
-		log.Printf("[GAME] Forfeit timer ended. Player %s did not reconnect in time. Game %s forfeited.", playerID, gameID)
-		if disconnectedPlayerSymbol == "X" {
-			game.Status = StatusWinO
-			updateLeaderboard(game.PlayerO)
-		} else {
-			game.Status = StatusWinX
-			updateLeaderboard(game.PlayerX)
-		}
-		saveGame(ctx, game)
-		rdb.SRem(ctx, inGameKey, game.PlayerX, game.PlayerO)
-
-		finalResponse := Message{Type: "game_update", Payload: game}
-		finalResponseJSON, _ := json.Marshal(finalResponse)
-		rdb.Publish(ctx, channel, finalResponseJSON)
-	} else {
-		log.Printf("[GAME] Forfeit timer ended. Player %s appears to have reconnected to game %s. No action taken.", playerID, gameID)
+// isOver reports whether the game has reached a final status.
+func (g *Game) isOver() bool {
+	switch g.Status {
+	case StatusPlaying, StatusDisconnectedX, StatusDisconnectedO:
+		return false
 	}
+	return true
 }
 
-func (g *Game) applyMove(index int, player string) {
+func (g *Game) applyMove(index int, player string, at time.Time) {
 	g.Board[index] = player
+	g.Moves = append(g.Moves, Move{Index: index, Player: player, PlayedAt: at})
 	if g.checkForWin(player) {
 		if player == "X" {
 			g.Status = StatusWinX
@@ -119,38 +152,3 @@ func (g *Game) checkForDraw() bool {
 	}
 	return true
 }
-
-func saveGame(ctx context.Context, game *Game) error {
-	key := fmt.Sprintf("game:%s", game.ID)
-	jsonData, err := json.Marshal(game)
-	if err != nil {
-		log.Printf("[GAME] ERROR marshalling game state for game %s: %v", game.ID, err)
-		return err
-	}
-	err = rdb.Set(ctx, key, jsonData, 0).Err()
-	if err != nil {
-		log.Printf("[GAME] ERROR saving game state to Redis for game %s: %v", game.ID, err)
-		return err
-	}
-	log.Printf("[GAME] Game state saved for game %s. Status: %s, Turn: %s", game.ID, game.Status, game.Turn)
-	return nil
-}
-
-func getGame(ctx context.Context, gameID string) (*Game, error) {
-	key := fmt.Sprintf("game:%s", gameID)
-	jsonData, err := rdb.Get(ctx, key).Result()
-	if err != nil {
-		if err != redis.Nil {
-			log.Printf("[GAME] ERROR retrieving game state from Redis for game %s: %v", gameID, err)
-		}
-		return nil, err
-	}
-	log.Printf("[GAME] Game state retrieved for game %s.", gameID)
-	var game Game
-	err = json.Unmarshal([]byte(jsonData), &game)
-	if err != nil {
-		log.Printf("[GAME] ERROR unmarshalling game state for game %s: %v", gameID, err)
-		return nil, err
-	}
-	return &game, nil
-}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const (
+	EventCreated    = "created"
+	EventMove       = "move"
+	EventDisconnect = "disconnect"
+	EventReconnect  = "reconnect"
+	EventForfeit    = "forfeit"
+	EventTimeout    = "timeout"
+	EventAbandoned  = "abandoned"
+	EventFinished   = "finished"
+)
+
+// ErrConflict is returned when a game's event log moved on since the state
+// the caller based its change on.
+var ErrConflict = errors.New("store: concurrent update")
+
+// GameEvent is one state transition in a game's append-only log. The
+// current Game is always the fold of its events.
+type GameEvent struct {
+	ID       string    `json:"id"`
+	Type     string    `json:"type"`
+	At       time.Time `json:"at"`
+	PlayerID string    `json:"playerId,omitempty"`
+	Index    int       `json:"index"`
+	Status   string    `json:"status,omitempty"`
+	Game     *Game     `json:"game,omitempty"`
+}
+
+type GameEventsResponse struct {
+	GameID string      `json:"gameId"`
+	Game   *Game       `json:"game"`
+	Events []GameEvent `json:"events"`
+}
+
+// apply folds a single event into the game.
+func (g *Game) apply(event GameEvent) {
+	switch event.Type {
+	case EventCreated:
+		*g = *event.Game
+		if g.Moves == nil {
+			g.Moves = []Move{}
+		}
+	case EventMove:
+		symbol := g.symbolOf(event.PlayerID)
+		g.applyMove(event.Index, symbol, event.At)
+		if g.Status == StatusPlaying {
+			if g.Turn == "X" {
+				g.Turn = "O"
+			} else {
+				g.Turn = "X"
+			}
+		}
+	case EventDisconnect:
+		if g.symbolOf(event.PlayerID) == "X" {
+			g.Status = StatusDisconnectedX
+		} else {
+			g.Status = StatusDisconnectedO
+		}
+	case EventReconnect:
+		g.Status = StatusPlaying
+	case EventForfeit, EventTimeout:
+		if g.symbolOf(event.PlayerID) == "X" {
+			g.Status = StatusWinO
+		} else {
+			g.Status = StatusWinX
+		}
+	case EventAbandoned:
+		g.Status = StatusAbandoned
+	case EventFinished:
+		if event.Status != "" {
+			g.Status = event.Status
+		}
+		finishedAt := event.At
+		g.FinishedAt = &finishedAt
+	}
+	g.EventID = event.ID
+}
+
+func (g *Game) symbolOf(playerID string) string {
+	if playerID == g.PlayerX {
+		return "X"
+	}
+	return "O"
+}
+
+// clone returns a copy that can be folded forward without touching g.
+func (g *Game) clone() *Game {
+	copied := *g
+	copied.Moves = append([]Move(nil), g.Moves...)
+	return &copied
+}
+
+func foldGameEvents(events []GameEvent) *Game {
+	game := &Game{}
+	for _, event := range events {
+		game.apply(event)
+	}
+	return game
+}
+
+// recordGameEvents appends events to the game's log and folds them into
+// game. The append only succeeds if the log still ends at game.EventID, so
+// two writers working from the same state cannot both win.
+func recordGameEvents(ctx context.Context, hub *Hub, game *Game, events ...GameEvent) error {
+	now := time.Now().UTC()
+	for i := range events {
+		if events[i].At.IsZero() {
+			events[i].At = now
+		}
+	}
+	previousEventID := game.EventID
+	ids, err := store.AppendGameEvents(ctx, game.ID, previousEventID, events)
+	if err != nil {
+		gameLog.Error("error appending game events", "game_id", game.ID, "error", err)
+		return err
+	}
+	for i := range events {
+		events[i].ID = ids[i]
+		game.apply(events[i])
+	}
+	restartMoveClock(game, previousEventID, hub.config.Game.MoveTimeout)
+	gameLog.Debug("game advanced", "game_id", game.ID, "event_id", game.EventID, "status", game.Status, "turn", game.Turn)
+	return nil
+}
+
+func getGame(ctx context.Context, gameID string) (*Game, error) {
+	events, err := store.GameEvents(ctx, gameID, "")
+	if err != nil {
+		gameLog.Error("error loading game events", "game_id", gameID, "error", err)
+		return nil, err
+	}
+	if len(events) == 0 {
+		return nil, ErrNotFound
+	}
+	return foldGameEvents(events), nil
+}
+
+// sendGameEvents replays the events a player missed after afterID, along
+// with the folded current state.
+func sendGameEvents(ctx context.Context, client *Client, gameID string, afterID string) {
+	events, err := store.GameEvents(ctx, gameID, "")
+	if err != nil || len(events) == 0 {
+		client.logger().Warn("could not load game events", "game_id", gameID, "error", err)
+		return
+	}
+	game := foldGameEvents(events)
+	if client.PlayerID != game.PlayerX && client.PlayerID != game.PlayerO {
+		client.logger().Warn("events requested for a game the player is not in", "game_id", gameID)
+		return
+	}
+
+	missed := events
+	for i, event := range events {
+		if event.ID == afterID {
+			missed = events[i+1:]
+			break
+		}
+	}
+
+	response := Message{Type: "game_events", Payload: GameEventsResponse{GameID: gameID, Game: game, Events: missed}}
+	responseJSON, _ := json.Marshal(response)
+	client.reply(responseJSON)
+}
+
+func handleGetGameEvents(ctx context.Context, client *Client, payload interface{}) {
+	payloadData, _ := json.Marshal(payload)
+	var eventsPayload GameEventsPayload
+	if err := json.Unmarshal(payloadData, &eventsPayload); err != nil {
+		client.logger().Warn("error unmarshalling get_game_events payload", "error", err)
+		return
+	}
+	if client.PlayerID == "" && !client.identify(ctx, eventsPayload.PlayerID) {
+		return
+	}
+	sendGameEvents(ctx, client, eventsPayload.GameID, eventsPayload.AfterEventID)
+}
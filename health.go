@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// readinessTimeout bounds the store ping made by /readyz.
+const readinessTimeout = 2 * time.Second
+
+var (
+	// subscribed is true while subscribeToDirectMessages is consuming this
+	// replica's channel.
+	subscribed atomic.Bool
+	// lastMatchmakingTick is when startMatchmaking last woke up, in unix
+	// nanoseconds.
+	lastMatchmakingTick atomic.Int64
+)
+
+// ReadinessReport is the /readyz response body. Checks maps each check to
+// "ok" or the reason it failed.
+type ReadinessReport struct {
+	Ready  bool              `json:"ready"`
+	Checks map[string]string `json:"checks"`
+}
+
+// handleHealthz reports that the process is up and serving HTTP.
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok\n"))
+}
+
+// handleReadyz reports whether this replica can serve players: the store is
+// reachable, the direct message subscription is alive, matchmaking is
+// ticking and the server is not draining.
+func handleReadyz(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	report := ReadinessReport{Ready: true, Checks: map[string]string{}}
+	check := func(name, failure string) {
+		if failure == "" {
+			report.Checks[name] = "ok"
+			return
+		}
+		report.Ready = false
+		report.Checks[name] = failure
+	}
+
+	pingCtx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+	if err := store.Ping(pingCtx); err != nil {
+		check("store", err.Error())
+	} else {
+		check("store", "")
+	}
+
+	if subscribed.Load() {
+		check("pubsub", "")
+	} else {
+		check("pubsub", "not subscribed to replica channel")
+	}
+
+	if since := time.Since(time.Unix(0, lastMatchmakingTick.Load())); since > 3*hub.config.Matchmaking.Interval {
+		check("matchmaking", "last tick "+since.Truncate(time.Second).String()+" ago")
+	} else {
+		check("matchmaking", "")
+	}
+
+	if hub.draining.Load() {
+		check("shutdown", "draining")
+	} else {
+		check("shutdown", "")
+	}
+
+	if !report.Ready {
+		mainLog.Warn("replica not ready", "checks", report.Checks)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !report.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
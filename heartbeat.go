@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+)
+
+const (
+	PresenceOnline = "online"
+	PresenceStale  = "stale"
+)
+
+// healthUpdate reports a connection going stale or recovering.
+type healthUpdate struct {
+	client *Client
+	stale  bool
+}
+
+type PresencePayload struct {
+	PlayerID string `json:"playerId"`
+	GameID   string `json:"gameId"`
+	Status   string `json:"status"`
+}
+
+// notifyOpponentPresence tells the other player of gameID whether playerID's
+// connection is healthy.
+func notifyOpponentPresence(playerID string, gameID string, status string) {
+	game, err := getGame(ctx, gameID)
+	if err != nil || game.Status != StatusPlaying {
+		return
+	}
+	opponentID := game.PlayerX
+	if playerID == game.PlayerX {
+		opponentID = game.PlayerO
+	}
+	presenceLog.Info("notifying opponent of presence change", "player_id", playerID, "status", status, "game_id", gameID, "opponent_id", opponentID)
+	response := Message{Type: "presence", Payload: PresencePayload{PlayerID: playerID, GameID: gameID, Status: status}}
+	responseJSON, _ := json.Marshal(response)
+	routeDirect(ctx, &directMessage{playerID: opponentID, message: responseJSON})
+}
@@ -1,78 +1,459 @@
 package main
 
 import (
-	"log"
+	"context"
+	"encoding/json"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
 )
 
 type directMessage struct {
 	playerID string
 	message  []byte
+	// gameID and seq are set for game_update messages.
+	gameID string
+	seq    int64
+	// disconnect closes the player's connections once message is queued.
+	disconnect bool
+}
+
+// ConnectionInfo describes one connection for the admin API.
+type ConnectionInfo struct {
+	ClientID    string    `json:"clientId"`
+	PlayerID    string    `json:"playerId,omitempty"`
+	GameID      string    `json:"gameId,omitempty"`
+	IP          string    `json:"ip"`
+	ConnectedAt time.Time `json:"connectedAt"`
+	Stale       bool      `json:"stale"`
+}
+
+// replayRequest carries the buffered updates a resuming client missed.
+type replayRequest struct {
+	client  *Client
+	gameID  string
+	updates []GameUpdate
+}
+
+// identifyRequest binds a connection to a player. done is closed once the
+// hub has updated its index; announced is then set if the player's presence
+// is being registered. hold starts holding the connection's live messages
+// in the same step, so none slip out ahead of a resume replay. gone is set
+// instead when the connection unregistered before the hub got to it.
+type identifyRequest struct {
+	client    *Client
+	playerID  string
+	hold      bool
+	done      chan struct{}
+	announced <-chan struct{}
+	gone      bool
 }
 
 type Hub struct {
-	clients    map[string]*Client
+	// config is read-only after startup; code that acts on behalf of the
+	// hub takes its settings from here.
+	config   *Config
+	origins  *originPolicy
+	limits   *rateLimits
+	upgrader websocket.Upgrader
+
+	clients map[string]*Client
+	// players indexes connections by PlayerID. A player may be connected
+	// from several devices at once.
+	players    map[string]map[*Client]struct{}
 	register   chan *Client
 	unregister chan *Client
+	identify   chan *identifyRequest
 	direct     chan *directMessage
+	broadcast  chan []byte
+	inspect    chan chan []ConnectionInfo
+	replay     chan *replayRequest
+	snapshot   chan chan []string
+	health     chan *healthUpdate
+	drain      chan chan []chan struct{}
+	// presence applies registry and queue changes off the hub goroutine.
+	presence *presenceQueue
+
+	// draining is set once shutdown starts. Disconnects after that do not
+	// start forfeit timers, since the players are expected to reconnect to
+	// another replica.
+	draining atomic.Bool
+	// maintenance is nil unless maintenance mode is on. refreshMaintenance
+	// updates it under maintenanceMu; everything else only loads it.
+	maintenance   atomic.Pointer[Maintenance]
+	maintenanceMu sync.Mutex
 }
 
-func newHub() *Hub {
+func newHub(config *Config) *Hub {
+	// Config.validate has already checked the origins.
+	origins, _ := newOriginPolicy(config.Origins.Allowed)
 	return &Hub{
+		config:  config,
+		origins: origins,
+		limits:  newRateLimits(config.RateLimit),
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  config.WebSocket.ReadBufferSize,
+			WriteBufferSize: config.WebSocket.WriteBufferSize,
+			CheckOrigin:     origins.checkWebSocket,
+		},
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
+		identify:   make(chan *identifyRequest),
 		clients:    make(map[string]*Client),
+		players:    make(map[string]map[*Client]struct{}),
 		direct:     make(chan *directMessage),
+		broadcast:  make(chan []byte),
+		inspect:    make(chan chan []ConnectionInfo),
+		replay:     make(chan *replayRequest),
+		snapshot:   make(chan chan []string),
+		health:     make(chan *healthUpdate),
+		drain:      make(chan chan []chan struct{}),
+		presence:   newPresenceQueue(),
 	}
 }
 
+var hubLog = newLogger("hub")
+
 func (h *Hub) run() {
-	log.Println("[HUB] Hub is running...")
+	hubLog.Info("hub running")
+	go h.presence.run()
 	for {
 		select {
 		case client := <-h.register:
+			if h.draining.Load() {
+				close(client.done)
+				continue
+			}
 			h.clients[client.ID] = client
-			log.Printf("[HUB] Client %s registered. Total clients: %d", client.ID, len(h.clients))
+			connectedClients.Set(float64(len(h.clients)))
+			hubLog.Debug("client registered", "client_id", client.ID, "clients", len(h.clients))
+			if m := h.maintenance.Load(); m != nil {
+				h.deliver(client, m.announcement())
+			}
 
 		case client := <-h.unregister:
 			if _, ok := h.clients[client.ID]; ok {
-				log.Printf("[HUB] Unregistering client %s (PlayerID: %s)", client.ID, client.PlayerID)
+				h.remove(client)
+			}
 
-				if client.GameID != "" {
-					log.Printf("[HUB] In-game player %s disconnected from game %s. Starting forfeit timer.", client.PlayerID, client.GameID)
-					go handleGameDisconnect(client.PlayerID, client.GameID)
-				}
+		case req := <-h.identify:
+			if _, ok := h.clients[req.client.ID]; !ok {
+				// Indexing it now would leave a closed connection registered.
+				req.gone = true
+				close(req.done)
+				continue
+			}
+			if req.client.PlayerID != req.playerID {
+				h.unindex(req.client, nil)
+				req.client.PlayerID = req.playerID
+				req.announced = h.index(req.client)
+			}
+			if req.hold {
+				req.client.holding = true
+			}
+			close(req.done)
 
-				if client.PlayerID != "" {
-					rdb.LRem(ctx, matchmakingQueueKey, 0, client.PlayerID)
-					rdb.SRem(ctx, inQueueKey, client.PlayerID)
-					log.Printf("[HUB] Player %s removed from matchmaking queue due to disconnect.", client.PlayerID)
+		case dm := <-h.direct:
+			clients := h.players[dm.playerID]
+			if len(clients) == 0 {
+				hubLog.Debug("no local connection for direct message", "player_id", dm.playerID, "game_id", dm.gameID)
+			}
+			for client := range clients {
+				if dm.gameID != "" {
+					client.GameID = dm.gameID
+				}
+				switch {
+				case dm.disconnect:
+					// Mark the connection first so readPump stops handling
+					// the player's messages, then flush the explanation.
+					client.logger().Info("disconnecting player on request")
+					client.closeWith(websocket.ClosePolicyViolation, "disconnected by server")
+					if h.deliver(client, dm.message) {
+						h.remove(client)
+					}
+				case client.holding:
+					client.held = append(client.held, dm)
+				default:
+					h.deliver(client, dm.message)
 				}
+			}
 
-				delete(h.clients, client.ID)
-				close(client.send)
-				log.Printf("[HUB] Client %s connection closed. Total clients: %d", client.ID, len(h.clients))
+		case message := <-h.broadcast:
+			for _, client := range h.clients {
+				h.deliver(client, message)
 			}
+			hubLog.Info("broadcast delivered", "clients", len(h.clients))
 
-		case dm := <-h.direct:
-			log.Printf("[HUB] Routing direct message to PlayerID: %s", dm.playerID)
-			var foundClient bool
+		case reply := <-h.inspect:
+			connections := make([]ConnectionInfo, 0, len(h.clients))
 			for _, client := range h.clients {
-				if client.PlayerID == dm.playerID {
-					select {
-					case client.send <- dm.message:
-						log.Printf("[HUB] Message sent successfully to PlayerID: %s (ConnectionID: %s)", dm.playerID, client.ID)
-					default:
-						log.Printf("[HUB] Send buffer full for PlayerID: %s. Closing connection.", dm.playerID)
-						close(client.send)
-						delete(h.clients, client.ID)
-					}
-					foundClient = true
-					break
-				}
+				connections = append(connections, ConnectionInfo{
+					ClientID:    client.ID,
+					PlayerID:    client.PlayerID,
+					GameID:      client.GameID,
+					IP:          client.ip,
+					ConnectedAt: client.connectedAt,
+					Stale:       client.stale,
+				})
 			}
-			if !foundClient {
-				log.Printf("[HUB] Could not find an active client for PlayerID: %s", dm.playerID)
+			reply <- connections
+
+		case rr := <-h.replay:
+			h.finishReplay(rr)
+
+		case update := <-h.health:
+			h.setStale(update.client, update.stale)
+
+		case reply := <-h.drain:
+			reply <- h.drainClients()
+
+		case reply := <-h.snapshot:
+			players := make([]string, 0, len(h.players))
+			for playerID := range h.players {
+				players = append(players, playerID)
 			}
+			reply <- players
+		}
+	}
+}
+
+// identify binds the connection to a player. It blocks until the hub has
+// indexed the connection, so handlers can route to the player right away.
+// A banned player is told why and reported false, and readPump then closes
+// the connection.
+func (c *Client) identify(ctx context.Context, playerID string) bool {
+	return c.bind(ctx, playerID, false)
+}
+
+// identifyHolding identifies the connection like identify and, once the
+// player is let in, holds its live messages until the hub gets a replay.
+func (c *Client) identifyHolding(ctx context.Context, playerID string) bool {
+	return c.bind(ctx, playerID, true)
+}
+
+func (c *Client) bind(ctx context.Context, playerID string, hold bool) bool {
+	if c.PlayerID == playerID {
+		if !hold {
+			return true
+		}
+	} else if ban, err := store.PlayerBan(ctx, playerID); err == nil {
+		c.logger().Info("banned player refused", "banned_player_id", playerID, "reason", ban.Reason)
+		c.sendError(ErrorPayload{Code: "banned", Message: ban.message()})
+		c.closeWith(websocket.ClosePolicyViolation, "banned")
+		return false
+	}
+	req := &identifyRequest{client: c, playerID: playerID, hold: hold, done: make(chan struct{})}
+	c.hub.identify <- req
+	<-req.done
+	if req.gone {
+		return false
+	}
+	if req.announced != nil {
+		// Matchmaking and routing look the player up in the registry.
+		<-req.announced
+	}
+	return true
+}
+
+// setStale records a connection's health. The opponent is told when the
+// player as a whole changes state: stale once every connection is stale,
+// online again as soon as one recovers.
+func (h *Hub) setStale(client *Client, stale bool) {
+	if _, ok := h.clients[client.ID]; !ok || client.stale == stale {
+		return
+	}
+	wasStale := h.playerStale(client.PlayerID)
+	client.stale = stale
+	isStale := h.playerStale(client.PlayerID)
+	if client.PlayerID == "" || client.GameID == "" || wasStale == isStale {
+		return
+	}
+	status := PresenceOnline
+	if isStale {
+		status = PresenceStale
+	}
+	go notifyOpponentPresence(client.PlayerID, client.GameID, status)
+}
+
+func (h *Hub) playerStale(playerID string) bool {
+	clients := h.players[playerID]
+	if len(clients) == 0 {
+		return false
+	}
+	for client := range clients {
+		if !client.stale {
+			return false
+		}
+	}
+	return true
+}
+
+// connections describes every connection held by this replica.
+func (h *Hub) connections() []ConnectionInfo {
+	reply := make(chan []ConnectionInfo)
+	h.inspect <- reply
+	return <-reply
+}
+
+// connectedPlayers returns the IDs of every player with a connection to
+// this replica.
+func (h *Hub) connectedPlayers() []string {
+	reply := make(chan []string)
+	h.snapshot <- reply
+	return <-reply
+}
+
+// index adds the client to its player's connection set. The first
+// connection registers the player's presence on this replica, and index
+// returns a channel closed once that is done.
+func (h *Hub) index(client *Client) <-chan struct{} {
+	if client.PlayerID == "" {
+		return nil
+	}
+	var announced <-chan struct{}
+	clients, ok := h.players[client.PlayerID]
+	if !ok {
+		clients = make(map[*Client]struct{})
+		h.players[client.PlayerID] = clients
+		announced = h.presence.push(&presenceChange{playerID: client.PlayerID, online: true})
+	}
+	for sibling := range clients {
+		if client.GameID == "" {
+			client.GameID = sibling.GameID
+		}
+	}
+	clients[client] = struct{}{}
+	client.logger().Info("client identified", "connections", len(clients))
+	return announced
+}
+
+// unindex removes the client from its player's connection set and reports
+// whether it was the player's last connection to this replica. If so, the
+// player's presence is withdrawn and then, once that is done, runs.
+func (h *Hub) unindex(client *Client, then func()) bool {
+	clients, ok := h.players[client.PlayerID]
+	if !ok {
+		return false
+	}
+	delete(clients, client)
+	if len(clients) > 0 {
+		return false
+	}
+	delete(h.players, client.PlayerID)
+	h.presence.push(&presenceChange{playerID: client.PlayerID, then: then})
+	return true
+}
+
+// remove closes a connection. Queue removal and the forfeit timer only
+// apply once the player's last device has gone. Both happen after the
+// presence worker has withdrawn the player, so they see the player offline.
+func (h *Hub) remove(client *Client) {
+	delete(h.clients, client.ID)
+	connectedClients.Set(float64(len(h.clients)))
+	close(client.done)
+	client.logger().Info("connection closed", "clients", len(h.clients))
+
+	if client.PlayerID == "" {
+		return
+	}
+	var forfeit func()
+	if client.GameID != "" && !h.draining.Load() {
+		playerID, gameID := client.PlayerID, client.GameID
+		forfeit = func() { handleGameDisconnect(h, playerID, gameID) }
+	}
+	if !h.unindex(client, forfeit) {
+		client.logger().Debug("player still has other connections", "connections", len(h.players[client.PlayerID]))
+		return
+	}
+
+	if client.GameID != "" && h.draining.Load() {
+		client.logger().Info("server draining, forfeit timer not started", "game_id", client.GameID)
+	} else if client.GameID != "" {
+		client.logger().Info("in-game player disconnected", "game_id", client.GameID)
+	}
+}
+
+// shutdown tells every client the server is going away and closes their
+// connections once their queued messages are written. It returns when all
+// of them are flushed and their presence withdrawn, or ctx expires.
+func (h *Hub) shutdown(ctx context.Context) error {
+	h.draining.Store(true)
+	reply := make(chan []chan struct{})
+	h.drain <- reply
+	for _, flushed := range <-reply {
+		select {
+		case <-flushed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	h.presence.flush()
+	return nil
+}
+
+// drainClients queues a server_shutdown message for every client and
+// drops it, which makes its writePump flush and hang up.
+// Reconnect hints are spread out so clients do not all return at once.
+func (h *Hub) drainClients() []chan struct{} {
+	hubLog.Info("draining clients", "clients", len(h.clients))
+	flushed := make([]chan struct{}, 0, len(h.clients))
+	for _, client := range h.clients {
+		flushed = append(flushed, client.flushed)
+		retryAfter := time.Second + time.Duration(rand.Int63n(int64(4*time.Second)))
+		response := Message{Type: "server_shutdown", Payload: ServerShutdownPayload{
+			Reason:       "server restarting, reconnect and resume",
+			RetryAfterMs: retryAfter.Milliseconds(),
+		}}
+		responseJSON, _ := json.Marshal(response)
+		if h.deliver(client, responseJSON) {
+			h.remove(client)
+		}
+	}
+	return flushed
+}
+
+// deliver queues a message on the client's connection, dropping the client
+// if its send buffer is full. It reports whether the client is still open.
+func (h *Hub) deliver(client *Client, message []byte) bool {
+	select {
+	case client.send <- message:
+		client.logger().Debug("message queued", "bytes", len(message))
+		return true
+	default:
+		droppedSends.Inc()
+		client.logger().Warn("send buffer full, closing connection")
+		h.remove(client)
+		return false
+	}
+}
+
+// finishReplay sends the missed updates, then whatever arrived live while
+// they were loading, skipping live updates the replay already covered.
+func (h *Hub) finishReplay(rr *replayRequest) {
+	client := rr.client
+	client.holding = false
+	held := client.held
+	client.held = nil
+	if _, ok := h.clients[client.ID]; !ok {
+		return
+	}
+
+	var lastSeq int64
+	for _, update := range rr.updates {
+		if !h.deliver(client, update.Message) {
+			return
+		}
+		lastSeq = update.Seq
+	}
+	for _, dm := range held {
+		if dm.gameID == rr.gameID && dm.seq != 0 && dm.seq <= lastSeq {
+			continue
+		}
+		if !h.deliver(client, dm.message) {
+			return
 		}
 	}
 }
@@ -2,27 +2,284 @@ package main
 
 import (
 	"context"
-	"log"
+	"errors"
+	"fmt"
+	"time"
 )
 
 const leaderboardKey = "leaderboard:wins"
 
+const (
+	WindowAllTime = "all_time"
+	WindowDaily   = "daily"
+	WindowWeekly  = "weekly"
+)
+
+// Rolling windows outlive their period long enough for the previous day or
+// week to stay queryable (e.g. "player of the week").
+const dailyLeaderboardTTL = 8 * 24 * time.Hour
+const weeklyLeaderboardTTL = 5 * 7 * 24 * time.Hour
+
+const maxAroundMe = 25
+
+// errInvalidLeaderboard wraps requests for a leaderboard that does not
+// exist, as opposed to failures reading one.
+var errInvalidLeaderboard = errors.New("invalid leaderboard request")
+
 type LeaderboardEntry struct {
-	Name  string  `json:"name"`
-	Score float64 `json:"score"`
+	Rank     int64   `json:"rank"`
+	PlayerID string  `json:"playerId"`
+	Name     string  `json:"name"`
+	Score    float64 `json:"score"`
+}
+
+type LeaderboardResponse struct {
+	Metric   string             `json:"metric"`
+	Window   string             `json:"window,omitempty"`
+	Period   string             `json:"period,omitempty"`
+	Season   int                `json:"season,omitempty"`
+	StartsAt *time.Time         `json:"startsAt,omitempty"`
+	EndsAt   *time.Time         `json:"endsAt,omitempty"`
+	Offset   int64              `json:"offset"`
+	Limit    int64              `json:"limit"`
+	Total    int64              `json:"total"`
+	Entries  []LeaderboardEntry `json:"entries"`
+	Me       *LeaderboardEntry  `json:"me,omitempty"`
+	Around   []LeaderboardEntry `json:"around,omitempty"`
+}
+
+// leaderboardSource is either a live sorted set or, for archived seasons,
+// a snapshot of the final standings.
+type leaderboardSource struct {
+	key      string
+	snapshot []LeaderboardEntry
+	archived bool
+}
+
+var leaderboardLog = newLogger("leaderboard")
+
+func updateLeaderboard(cfg LeaderboardConfig, winnerID string) {
+	leaderboardLog.Debug("incrementing winner's score", "player_id", winnerID)
+	now := time.Now().UTC()
+	dailyKey := windowLeaderboardKey(WindowDaily, dailyPeriod(now))
+	weeklyKey := windowLeaderboardKey(WindowWeekly, weeklyPeriod(now))
+
+	increments := []struct {
+		board string
+		ttl   time.Duration
+	}{
+		{leaderboardKey, 0},
+		{seasonLeaderboardKey(cfg.currentSeason()), 0},
+		{dailyKey, dailyLeaderboardTTL},
+		{weeklyKey, weeklyLeaderboardTTL},
+	}
+	for _, increment := range increments {
+		if err := store.IncrementScore(context.Background(), increment.board, winnerID, 1, increment.ttl); err != nil {
+			leaderboardLog.Error("error updating leaderboard", "board", increment.board, "player_id", winnerID, "error", err)
+		}
+	}
+}
+
+// getLeaderboard returns a page of the requested leaderboard. Wins can be
+// scoped to a season (0 selects the current one) or a rolling window; the
+// other metrics are all-time only. When playerID is set the response also
+// carries that player's rank and the aroundMe players directly above and
+// below them.
+func getLeaderboard(cfg LeaderboardConfig, request LeaderboardPayload, playerID string) (*LeaderboardResponse, error) {
+	var response *LeaderboardResponse
+	var source leaderboardSource
+	var err error
+	switch {
+	case request.Metric != "" && request.Metric != MetricWins:
+		response, source, err = resolveMetricLeaderboard(request)
+	case request.Season != nil:
+		response, source, err = resolveSeasonLeaderboard(cfg, *request.Season)
+	default:
+		response, source, err = resolveWindowLeaderboard(request.Window, request.Period)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if response.Metric == "" {
+		response.Metric = MetricWins
+	}
+
+	response.Offset = request.Offset
+	if response.Offset < 0 {
+		response.Offset = 0
+	}
+	response.Limit = request.Limit
+	if response.Limit <= 0 {
+		response.Limit = cfg.Size
+	}
+	if response.Limit > cfg.MaxLimit {
+		response.Limit = cfg.MaxLimit
+	}
+
+	leaderboardLog.Debug("fetching leaderboard", "metric", response.Metric, "limit", response.Limit, "offset", response.Offset, "window", response.Window, "period", response.Period, "season", response.Season)
+	if response.Total, err = source.total(); err != nil {
+		return nil, err
+	}
+	if response.Entries, err = source.rangeByRank(response.Offset, response.Offset+response.Limit-1); err != nil {
+		return nil, err
+	}
+
+	if playerID == "" {
+		return response, nil
+	}
+	if response.Me, err = source.rankOf(playerID); err != nil || response.Me == nil {
+		return response, err
+	}
+	aroundMe := request.AroundMe
+	if aroundMe > maxAroundMe {
+		aroundMe = maxAroundMe
+	}
+	if aroundMe > 0 {
+		index := response.Me.Rank - 1
+		from := index - aroundMe
+		if from < 0 {
+			from = 0
+		}
+		if response.Around, err = source.rangeByRank(from, index+aroundMe); err != nil {
+			return nil, err
+		}
+	}
+	return response, nil
 }
 
-func updateLeaderboard(winnerID string) {
-	log.Printf("[LEADERBOARD] Incrementing score for winner ID: %s", winnerID)
-	_, err := rdb.ZIncrBy(context.Background(), leaderboardKey, 1, winnerID).Result()
+func resolveMetricLeaderboard(request LeaderboardPayload) (*LeaderboardResponse, leaderboardSource, error) {
+	if request.Season != nil || (request.Window != "" && request.Window != WindowAllTime) {
+		return nil, leaderboardSource{}, fmt.Errorf("%w: metric %q does not support seasons or windows", errInvalidLeaderboard, request.Metric)
+	}
+	key, ok := allTimeLeaderboardKey(request.Metric)
+	if !ok {
+		return nil, leaderboardSource{}, fmt.Errorf("%w: unknown leaderboard metric %q", errInvalidLeaderboard, request.Metric)
+	}
+	return &LeaderboardResponse{Metric: request.Metric, Window: WindowAllTime}, leaderboardSource{key: key}, nil
+}
+
+// allTimeLeaderboardKey returns the all-time board ranking players by metric.
+func allTimeLeaderboardKey(metric string) (string, bool) {
+	switch metric {
+	case MetricWins:
+		return leaderboardKey, true
+	case MetricRating:
+		return ratingLeaderboardKey, true
+	case MetricWinRate:
+		return winRateLeaderboardKey, true
+	case MetricStreak:
+		return streakLeaderboardKey, true
+	}
+	return "", false
+}
+
+func resolveWindowLeaderboard(window string, period string) (*LeaderboardResponse, leaderboardSource, error) {
+	if window == "" {
+		window = WindowAllTime
+	}
+	key := leaderboardKey
+	switch window {
+	case WindowAllTime:
+		period = ""
+	case WindowDaily:
+		if period == "" {
+			period = dailyPeriod(time.Now().UTC())
+		}
+		key = windowLeaderboardKey(window, period)
+	case WindowWeekly:
+		if period == "" {
+			period = weeklyPeriod(time.Now().UTC())
+		}
+		key = windowLeaderboardKey(window, period)
+	default:
+		return nil, leaderboardSource{}, fmt.Errorf("%w: unknown leaderboard window %q", errInvalidLeaderboard, window)
+	}
+	return &LeaderboardResponse{Window: window, Period: period}, leaderboardSource{key: key}, nil
+}
+
+func resolveSeasonLeaderboard(cfg LeaderboardConfig, season int) (*LeaderboardResponse, leaderboardSource, error) {
+	selected := season
+	if selected <= 0 {
+		selected = cfg.currentSeason()
+	}
+	response := &LeaderboardResponse{Season: selected}
+	startsAt, endsAt := cfg.seasonBounds(selected)
+	response.StartsAt, response.EndsAt = &startsAt, &endsAt
+	if selected > cfg.currentSeason() {
+		return response, leaderboardSource{archived: true}, nil
+	}
+	source, err := seasonLeaderboardSource(selected)
+	return response, source, err
+}
+
+func (s leaderboardSource) total() (int64, error) {
+	if s.archived {
+		return int64(len(s.snapshot)), nil
+	}
+	return store.BoardSize(context.Background(), s.key)
+}
+
+// rangeByRank returns the entries between two zero-based positions,
+// inclusive.
+func (s leaderboardSource) rangeByRank(start, stop int64) ([]LeaderboardEntry, error) {
+	if !s.archived {
+		return readLeaderboard(s.key, start, stop)
+	}
+	total := int64(len(s.snapshot))
+	if start >= total {
+		return []LeaderboardEntry{}, nil
+	}
+	if stop >= total {
+		stop = total - 1
+	}
+	return s.snapshot[start : stop+1], nil
+}
+
+// rankOf returns the player's entry, or nil when they are unranked.
+func (s leaderboardSource) rankOf(playerID string) (*LeaderboardEntry, error) {
+	if s.archived {
+		for i := range s.snapshot {
+			if s.snapshot[i].PlayerID == playerID {
+				entry := s.snapshot[i]
+				return &entry, nil
+			}
+		}
+		return nil, nil
+	}
+
+	rank, err := store.Rank(context.Background(), s.key, playerID)
+	if err == ErrNotFound {
+		return nil, nil
+	}
 	if err != nil {
-		log.Printf("[LEADERBOARD] Error updating leaderboard: %v", err)
+		return nil, err
+	}
+	entries, err := readLeaderboard(s.key, rank, rank)
+	if err != nil || len(entries) == 0 {
+		return nil, err
 	}
+	return &entries[0], nil
+}
+
+// dailyPeriod identifies a UTC calendar day, e.g. "2026-10-18".
+func dailyPeriod(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+// weeklyPeriod identifies an ISO week, e.g. "2026-W42".
+func weeklyPeriod(t time.Time) string {
+	year, week := t.ISOWeek()
+	return fmt.Sprintf("%d-W%02d", year, week)
+}
+
+func windowLeaderboardKey(window string, period string) string {
+	return fmt.Sprintf("%s:%s:%s", leaderboardKey, window, period)
 }
 
-func getLeaderboard() ([]LeaderboardEntry, error) {
-	log.Println("[LEADERBOARD] Fetching top 10 scores.")
-	idScores, err := rdb.ZRevRangeWithScores(context.Background(), leaderboardKey, 0, 9).Result()
+// readLeaderboard hydrates the [start, stop] range of a sorted set with
+// player display names.
+func readLeaderboard(key string, start, stop int64) ([]LeaderboardEntry, error) {
+	idScores, err := store.RangeByRank(context.Background(), key, start, stop)
 	if err != nil {
 		return nil, err
 	}
@@ -33,25 +290,25 @@ func getLeaderboard() ([]LeaderboardEntry, error) {
 
 	var playerIDs []string
 	for _, idScore := range idScores {
-		playerIDs = append(playerIDs, idScore.Member.(string))
+		playerIDs = append(playerIDs, idScore.Member)
 	}
 
-	names, err := rdb.HMGet(context.Background(), "player:names", playerIDs...).Result()
+	names, err := store.GetPlayerNames(context.Background(), playerIDs)
 	if err != nil {
 		return nil, err
 	}
 
 	var leaderboard []LeaderboardEntry
 	for i, idScore := range idScores {
-		var playerName string
-		if names[i] != nil {
-			playerName = names[i].(string)
-		} else {
+		playerName := names[i]
+		if playerName == "" {
 			playerName = "Unknown Player"
 		}
 		leaderboard = append(leaderboard, LeaderboardEntry{
-			Name:  playerName,
-			Score: idScore.Score,
+			Rank:     start + int64(i) + 1,
+			PlayerID: playerIDs[i],
+			Name:     playerName,
+			Score:    idScore.Score,
 		})
 	}
 
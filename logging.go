@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// logLevel is the minimum level written, set from LOG_LEVEL.
+var logLevel = new(slog.LevelVar)
+
+// Sampling limits repeated records below warn level: within each second
+// the first sampleInitial records with the same level and message are
+// written, then every sampleThereafter-th. sampleInitial 0 disables it.
+var (
+	sampleInitial    = 100
+	sampleThereafter = 100
+)
+
+// redactLogs replaces the values of redactedKeys with a placeholder.
+var redactLogs = true
+
+// redactedKeys hold player-supplied or bulky data that should not reach
+// the logs unless redaction is turned off.
+var redactedKeys = map[string]bool{
+	"player_name": true,
+	"payload":     true,
+}
+
+// rootLogHandler is built at package initialisation so component loggers
+// can be declared as package variables; initLogging adjusts its settings.
+var rootLogHandler slog.Handler = &samplingHandler{
+	next: slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		Level:       logLevel,
+		ReplaceAttr: redactAttr,
+	}),
+	state: &sampleState{counts: make(map[string]int)},
+}
+
+// newLogger returns the logger for one component of the server. Every
+// record it writes carries a component field.
+func newLogger(component string) *slog.Logger {
+	return slog.New(rootLogHandler).With("component", component)
+}
+
+var mainLog = newLogger("main")
+
+// initLogging applies the logging settings and routes the standard
+// library logger through slog. Config.validate has checked the level.
+func initLogging(cfg LoggingConfig) {
+	slog.SetDefault(slog.New(rootLogHandler))
+	logLevel.UnmarshalText([]byte(cfg.Level))
+	sampleInitial = cfg.SampleInitial
+	sampleThereafter = cfg.SampleThereafter
+	redactLogs = cfg.Redact
+}
+
+// fatal logs msg at error level and exits.
+func fatal(logger *slog.Logger, msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+}
+
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	if redactLogs && redactedKeys[a.Key] {
+		return slog.String(a.Key, "[REDACTED]")
+	}
+	return a
+}
+
+type sampleState struct {
+	mu          sync.Mutex
+	windowStart time.Time
+	counts      map[string]int
+}
+
+// samplingHandler drops repeated low-severity records; see sampleInitial.
+type samplingHandler struct {
+	next  slog.Handler
+	state *sampleState
+}
+
+func (h *samplingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *samplingHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < slog.LevelWarn && sampleInitial > 0 && !h.state.allow(r) {
+		return nil
+	}
+	return h.next.Handle(ctx, r)
+}
+
+func (h *samplingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &samplingHandler{next: h.next.WithAttrs(attrs), state: h.state}
+}
+
+func (h *samplingHandler) WithGroup(name string) slog.Handler {
+	return &samplingHandler{next: h.next.WithGroup(name), state: h.state}
+}
+
+func (s *sampleState) allow(r slog.Record) bool {
+	key := r.Level.String() + "|" + r.Message
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.Time.Sub(s.windowStart) >= time.Second {
+		s.windowStart = r.Time
+		clear(s.counts)
+	}
+	s.counts[key]++
+	n := s.counts[key]
+	return n <= sampleInitial || (n-sampleInitial)%sampleThereafter == 0
+}
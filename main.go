@@ -2,38 +2,126 @@ package main
 
 import (
 	"context"
-	"log"
+	"crypto/tls"
+	"errors"
+	"flag"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/cors"
 )
 
 func main() {
-	initRedis()
-
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	cfg, err := loadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fatal(mainLog, "invalid configuration", "error", err)
 	}
-	log.Printf("[MAIN] PORT not set, defaulting to %s", port)
+	initLogging(cfg.Logging)
+	initStore(cfg.Store)
+	initPresence(cfg.ReplicaID)
+	initTracing(cfg.Tracing)
+	initArchive(cfg.Archive)
+	mainLog.Info("listening port selected", "port", cfg.Port)
+
+	shutdown, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	hub := newHub()
+	hub := newHub(cfg)
+	if hub.origins.any {
+		originLog.Warn("accepting requests from any origin because origins.allowed contains *")
+	} else if len(cfg.Origins.Allowed) == 0 {
+		originLog.Warn("no browser origins allowed; list your web client's origin in origins.allowed")
+	}
 	go hub.run()
-	go startMatchmaking(hub)
-	go subscribeToGameUpdates(context.Background(), hub)
+	go startMatchmaking(shutdown, hub)
+	go startDeadlineScheduler(shutdown, hub)
+	go startReaper(shutdown, hub)
+	go startSeasonRollover(cfg.Leaderboard)
+	go startPresenceRefresh(hub)
+	go subscribeToDirectMessages(context.Background(), hub)
+	go subscribeToBroadcasts(context.Background(), hub)
+	go watchMaintenance(shutdown, hub)
+
+	api := http.NewServeMux()
+	api.Handle("/metrics", promhttp.Handler())
+	api.HandleFunc("/healthz", handleHealthz)
+	api.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		handleReadyz(hub, w, r)
+	})
 
+	// WebSocket upgrades check their origin in the upgrader and the admin
+	// API authenticates with a bearer token; CORS only applies to the
+	// public HTTP endpoints.
 	mux := http.NewServeMux()
 	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
 		serveWs(hub, w, r)
 	})
+	registerAdminRoutes(mux, hub)
+	mux.Handle("/", cors.New(cors.Options{
+		AllowOriginFunc: hub.origins.checkHTTP,
+		AllowedMethods:  []string{http.MethodHead, http.MethodGet, http.MethodPost},
+		AllowedHeaders:  []string{"*"},
+	}).Handler(api))
 
-	handler := cors.Default().Handler(mux)
+	serverAddr := ":" + cfg.Port
+	server := &http.Server{Addr: serverAddr, Handler: mux}
+	var certs *certReloader
+	if cfg.TLS.enabled() {
+		certs, err = newCertReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			fatal(tlsLog, "could not load TLS certificate", "error", err)
+		}
+		server.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certs.getCertificate,
+		}
+		go certs.watch(shutdown, cfg.TLS.ReloadInterval)
+	}
+	go func() {
+		mainLog.Info("server starting", "addr", serverAddr, "tls", certs != nil)
+		var err error
+		if certs != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			fatal(mainLog, "server stopped unexpectedly", "error", err)
+		}
+	}()
 
-	serverAddr := ":" + port
-	log.Println("[MAIN] Server starting on", serverAddr)
-	err := http.ListenAndServe(serverAddr, handler)
-	if err != nil {
-		log.Fatal("[MAIN] ListenAndServe Error: ", err)
+	var redirect *http.Server
+	if cfg.TLS.RedirectPort != "" {
+		redirect = &http.Server{Addr: ":" + cfg.TLS.RedirectPort, Handler: redirectToHTTPS(cfg.Port)}
+		go func() {
+			mainLog.Info("redirecting HTTP to HTTPS", "addr", redirect.Addr)
+			if err := redirect.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				fatal(mainLog, "redirect server stopped unexpectedly", "error", err)
+			}
+		}()
+	}
+
+	<-shutdown.Done()
+	stop()
+	mainLog.Info("shutdown signal received, draining connections", "timeout", cfg.ShutdownTimeout.String())
+	drainCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(drainCtx); err != nil {
+		mainLog.Error("error stopping HTTP server", "error", err)
+	}
+	if redirect != nil {
+		redirect.Shutdown(drainCtx)
+	}
+	if err := hub.shutdown(drainCtx); err != nil {
+		mainLog.Warn("timed out flushing client connections", "error", err)
 	}
+	shutdownTracing(drainCtx)
+	mainLog.Info("shutdown complete")
 }
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var maintenanceLog = newLogger("maintenance")
+
+const defaultMaintenanceMessage = "The server is undergoing maintenance."
+
+// Maintenance is the shared maintenance switch. While it is set, every
+// replica refuses find_match and stops pairing queued players, but games
+// already running play on.
+type Maintenance struct {
+	Message   string    `json:"message"`
+	StartedAt time.Time `json:"startedAt,omitzero"`
+	// EndsAt is the expected end, shown to players as a countdown. It does
+	// not end maintenance by itself.
+	EndsAt *time.Time `json:"endsAt,omitempty"`
+}
+
+// parseMaintenance reads the stored maintenance flag. Anything that is not
+// a JSON object is taken as the message, so operators can switch
+// maintenance on by hand with a plain string.
+func parseMaintenance(data []byte) *Maintenance {
+	var m Maintenance
+	if err := json.Unmarshal(data, &m); err != nil {
+		m = Maintenance{Message: strings.TrimSpace(string(data))}
+	}
+	return &m
+}
+
+func (m *Maintenance) text() string {
+	if m.Message == "" {
+		return defaultMaintenanceMessage
+	}
+	return m.Message
+}
+
+func (m *Maintenance) equal(other *Maintenance) bool {
+	if m == nil || other == nil {
+		return m == other
+	}
+	if (m.EndsAt == nil) != (other.EndsAt == nil) || m.EndsAt != nil && !m.EndsAt.Equal(*other.EndsAt) {
+		return false
+	}
+	return m.Message == other.Message && m.StartedAt.Equal(other.StartedAt)
+}
+
+// remaining is how long until the expected end, or zero if none was given
+// or it has passed.
+func (m *Maintenance) remaining(now time.Time) time.Duration {
+	if m.EndsAt == nil {
+		return 0
+	}
+	return max(m.EndsAt.Sub(now), 0)
+}
+
+// errorPayload answers a find_match refused during maintenance.
+func (m *Maintenance) errorPayload() ErrorPayload {
+	message := m.text() + " New matches are paused"
+	if m.EndsAt != nil {
+		message += " until about " + m.EndsAt.UTC().Format("15:04 MST")
+	}
+	return ErrorPayload{
+		Code:         "maintenance",
+		Message:      message + "; games in progress are not affected.",
+		MessageType:  "find_match",
+		RetryAfterMs: m.remaining(time.Now()).Milliseconds(),
+	}
+}
+
+func (m *Maintenance) announcement() []byte {
+	data, _ := json.Marshal(Message{Type: "server_announcement", Payload: ServerAnnouncementPayload{
+		Kind:    AnnouncementMaintenanceStart,
+		Message: m.text(),
+		SentAt:  time.Now().UTC(),
+		EndsAt:  m.EndsAt,
+	}})
+	return data
+}
+
+// watchMaintenance polls the shared flag until ctx is done, so maintenance
+// switched on by any replica, or directly in the store, reaches this one.
+func watchMaintenance(ctx context.Context, hub *Hub) {
+	refreshMaintenance(ctx, hub)
+	ticker := time.NewTicker(hub.config.Maintenance.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		refreshMaintenance(ctx, hub)
+	}
+}
+
+// refreshMaintenance loads the shared flag and, if it changed, updates the
+// hub and announces the change to every local client. A failed read keeps
+// the current state.
+func refreshMaintenance(ctx context.Context, hub *Hub) {
+	hub.maintenanceMu.Lock()
+	defer hub.maintenanceMu.Unlock()
+
+	current, err := store.Maintenance(ctx)
+	if errors.Is(err, ErrNotFound) {
+		current, err = nil, nil
+	}
+	if err != nil {
+		maintenanceLog.Warn("error reading maintenance flag", "error", err)
+		return
+	}
+	previous := hub.maintenance.Load()
+	if current.equal(previous) {
+		return
+	}
+	hub.maintenance.Store(current)
+
+	if current == nil {
+		maintenanceMode.Set(0)
+		maintenanceLog.Info("maintenance mode ended")
+		data, _ := json.Marshal(Message{Type: "server_announcement", Payload: ServerAnnouncementPayload{
+			Kind:    AnnouncementMaintenanceEnd,
+			Message: "Maintenance is over. Matchmaking is open again.",
+			SentAt:  time.Now().UTC(),
+		}})
+		hub.broadcast <- data
+		return
+	}
+	maintenanceMode.Set(1)
+	maintenanceLog.Info("maintenance mode started", "message", current.Message, "ends_at", current.EndsAt)
+	hub.broadcast <- current.announcement()
+}
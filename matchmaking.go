@@ -1,8 +1,9 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
@@ -13,115 +14,154 @@ const inQueueKey = "matchmaking:in_queue"
 const inGameKey = "players_in_game"
 const playerNamesKey = "player:names"
 
-func handleFindMatch(client *Client, payload interface{}) {
+var matchmakingLog = newLogger("matchmaking")
+
+func handleFindMatch(ctx context.Context, client *Client, payload interface{}) {
 	payloadData, _ := json.Marshal(payload)
 	var findMatchPayload FindMatchPayload
 	if err := json.Unmarshal(payloadData, &findMatchPayload); err != nil {
-		log.Printf("[MATCHMAKING] Error unmarshalling find_match payload: %v", err)
+		client.logger().Warn("error unmarshalling find_match payload", "error", err)
 		return
 	}
 
-	client.PlayerID = findMatchPayload.PlayerID
+	if !client.identify(ctx, findMatchPayload.PlayerID) {
+		return
+	}
 	client.PlayerName = findMatchPayload.PlayerName
-	log.Printf("[MATCHMAKING] Handling find_match from PlayerID: %s, PlayerName: %s", client.PlayerID, client.PlayerName)
-	rdb.HSet(ctx, playerNamesKey, client.PlayerID, client.PlayerName)
+	client.logger().Info("find_match requested", "player_name", client.PlayerName)
+	store.SetPlayerName(ctx, client.PlayerID, client.PlayerName)
 
-	isAlreadyInGame, _ := rdb.SIsMember(ctx, inGameKey, client.PlayerID).Result()
-	if isAlreadyInGame {
-		log.Printf("[MATCHMAKING] REJECTED: Player %s tried to queue while already in a game.", client.PlayerID)
+	if maintenance := client.hub.maintenance.Load(); maintenance != nil {
+		client.logger().Info("find_match rejected, maintenance mode")
+		client.sendError(maintenance.errorPayload())
 		return
 	}
 
-	isAlreadyInQueue, _ := rdb.SIsMember(ctx, inQueueKey, client.PlayerID).Result()
-	if isAlreadyInQueue {
-		log.Printf("[MATCHMAKING] REJECTED: Player %s is already in the matchmaking queue.", client.PlayerID)
+	isAlreadyInGame, _ := store.IsInGame(ctx, client.PlayerID)
+	if isAlreadyInGame {
+		client.logger().Info("find_match rejected, player already in a game")
+		client.sendError(ErrorPayload{Code: "already_in_game", Message: "finish or resume your current game first", MessageType: "find_match"})
 		return
 	}
 
-	if err := rdb.SAdd(ctx, inQueueKey, client.PlayerID).Err(); err != nil {
-		log.Printf("[MATCHMAKING] Error adding player to in_queue set: %v", err)
+	added, err := store.EnqueuePlayer(ctx, client.PlayerID)
+	if err != nil {
+		client.logger().Error("error adding player to matchmaking queue", "error", err)
+		client.sendError(ErrorPayload{Code: "internal_error", Message: "could not join the matchmaking queue, try again", MessageType: "find_match"})
 		return
 	}
-
-	if err := rdb.LPush(ctx, matchmakingQueueKey, client.PlayerID).Err(); err != nil {
-		log.Printf("[MATCHMAKING] Error adding client to matchmaking queue: %v", err)
-		rdb.SRem(ctx, inQueueKey, client.PlayerID)
+	if !added {
+		client.logger().Info("find_match rejected, player already queued")
+		client.sendError(ErrorPayload{Code: "already_queued", Message: "already searching for a match", MessageType: "find_match"})
 		return
 	}
 
-	log.Printf("[MATCHMAKING] Player %s (Name: %s) successfully added to matchmaking queue.", client.PlayerID, client.PlayerName)
+	client.logger().Info("player queued for matchmaking")
 }
 
-func startMatchmaking(hub *Hub) {
-	log.Println("[MATCHMAKING] Matchmaking service started...")
-	ticker := time.NewTicker(3 * time.Second)
+// startMatchmaking pairs queued players every few seconds until shutdown is
+// cancelled.
+func startMatchmaking(shutdown context.Context, hub *Hub) {
+	matchmakingLog.Info("matchmaking started")
+	ticker := time.NewTicker(hub.config.Matchmaking.Interval)
 	defer ticker.Stop()
+	lastMatchmakingTick.Store(time.Now().UnixNano())
+
+	for {
+		select {
+		case <-shutdown.Done():
+			matchmakingLog.Info("matchmaking stopped")
+			return
+		case <-ticker.C:
+		}
+		lastMatchmakingTick.Store(time.Now().UnixNano())
+		queueLength, _ := store.QueueLength(ctx)
+		queueLengthGauge.Set(float64(queueLength))
+
+		// Players stay queued through maintenance and are paired once it
+		// ends.
+		if queueLength >= 2 && hub.maintenance.Load() == nil {
+			matchmakingLog.Debug("pairing queued players", "queue_length", queueLength)
+			pairCtx, span := tracer.Start(context.Background(), "matchmaking.pair")
+			pairQueuedPlayers(pairCtx, hub)
+			span.End()
+		}
+	}
+}
 
-	for range ticker.C {
-		pipe := rdb.TxPipeline()
-		queueLength := pipe.LLen(ctx, matchmakingQueueKey)
-		pipe.Exec(ctx)
-
-		if queueLength.Val() >= 2 {
-			log.Printf("[MATCHMAKING] Ticker found %d players in queue. Attempting to create a match...", queueLength.Val())
-			player1ID, err1 := rdb.RPop(ctx, matchmakingQueueKey).Result()
-			player2ID, err2 := rdb.RPop(ctx, matchmakingQueueKey).Result()
-
-			if err1 != nil || err2 != nil {
-				log.Printf("[MATCHMAKING] Error popping players from queue: %v, %v", err1, err2)
-				if err1 == nil {
-					rdb.LPush(ctx, matchmakingQueueKey, player1ID)
-				}
-				continue
-			}
-
-			rdb.SRem(ctx, inQueueKey, player1ID, player2ID)
-			rdb.SAdd(ctx, inGameKey, player1ID, player2ID)
-			log.Printf("[MATCHMAKING] SUCCESS: Match found! Pairing Player X (%s) and Player O (%s)", player1ID, player2ID)
-
-			var client1, client2 *Client
-			for _, client := range hub.clients {
-				if client.PlayerID == player1ID {
-					client1 = client
-				}
-				if client.PlayerID == player2ID {
-					client2 = client
-				}
-			}
-
-			if client1 == nil || client2 == nil {
-				log.Println("[MATCHMAKING] FAILED: One or both clients disconnected. Rolling back.")
-				rdb.SRem(ctx, inGameKey, player1ID, player2ID)
-				if client1 != nil {
-					rdb.SAdd(ctx, inQueueKey, client1.PlayerID)
-					rdb.LPush(ctx, matchmakingQueueKey, client1.PlayerID)
-				}
-				if client2 != nil {
-					rdb.SAdd(ctx, inQueueKey, client2.PlayerID)
-					rdb.LPush(ctx, matchmakingQueueKey, client2.PlayerID)
-				}
-				continue
-			}
-
-			newGame := &Game{
-				ID:          uuid.NewString(),
-				PlayerX:     player1ID,
-				PlayerO:     player2ID,
-				PlayerXName: client1.PlayerName,
-				PlayerOName: client2.PlayerName,
-				Board:       [9]string{},
-				Turn:        "X",
-				Status:      StatusPlaying,
-			}
-
-			saveGame(ctx, newGame)
-			client1.GameID = newGame.ID
-			client2.GameID = newGame.ID
-
-			response := Message{Type: "match_found", Payload: newGame}
-			responseJSON, _ := json.Marshal(response)
-			hub.direct <- &directMessage{playerID: client1.PlayerID, message: responseJSON}
-			hub.direct <- &directMessage{playerID: client2.PlayerID, message: responseJSON}
+// pairQueuedPlayers pops the two longest-waiting players and starts a game
+// between them, re-queueing whoever is still available if that fails.
+func pairQueuedPlayers(ctx context.Context, hub *Hub) {
+	player1ID, queued1, err1 := store.PopQueuedPlayer(ctx)
+	player2ID, queued2, err2 := store.PopQueuedPlayer(ctx)
+
+	if err1 != nil || err2 != nil {
+		matchmakingLog.Error("error popping players from queue", "error", errors.Join(err1, err2))
+		if err1 == nil {
+			store.EnqueuePlayer(ctx, player1ID)
 		}
+		return
 	}
+	if player1ID == player2ID {
+		// Only possible for entries queued before enqueueing was atomic.
+		matchmakingLog.Warn("player was queued twice, requeueing once", "player_id", player1ID)
+		store.EnqueuePlayer(ctx, player1ID)
+		return
+	}
+
+	gameID := uuid.NewString()
+	store.MarkInGame(ctx, gameID, player1ID, player2ID)
+	logger := matchmakingLog.With("game_id", gameID, "player_x", player1ID, "player_o", player2ID)
+	logger.Info("match found")
+
+	online1, online2 := isOnline(ctx, player1ID), isOnline(ctx, player2ID)
+	if !online1 || !online2 {
+		logger.Info("match cancelled, a player is offline", "x_online", online1, "o_online", online2)
+		store.ClearInGame(ctx, player1ID, player2ID)
+		if online1 {
+			store.EnqueuePlayer(ctx, player1ID)
+		}
+		if online2 {
+			store.EnqueuePlayer(ctx, player2ID)
+		}
+		return
+	}
+
+	names, _ := store.GetPlayerNames(ctx, []string{player1ID, player2ID})
+	if len(names) != 2 {
+		names = []string{"", ""}
+	}
+	newGame := &Game{
+		ID:          gameID,
+		PlayerX:     player1ID,
+		PlayerO:     player2ID,
+		PlayerXName: names[0],
+		PlayerOName: names[1],
+		Board:       [9]string{},
+		Turn:        "X",
+		Status:      StatusPlaying,
+		Variant:     VariantClassic,
+		Moves:       []Move{},
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	if err := recordGameEvents(ctx, hub, newGame, GameEvent{Type: EventCreated, Game: newGame}); err != nil {
+		logger.Error("match cancelled, could not create game", "error", err)
+		store.ClearInGame(ctx, player1ID, player2ID)
+		store.EnqueuePlayer(ctx, player1ID)
+		store.EnqueuePlayer(ctx, player2ID)
+		return
+	}
+
+	gamesCreated.WithLabelValues("matchmaking").Inc()
+	for _, queuedAt := range []time.Time{queued1, queued2} {
+		if !queuedAt.IsZero() {
+			queueWait.Observe(time.Since(queuedAt).Seconds())
+		}
+	}
+
+	response := Message{Type: "match_found", Payload: newGame}
+	responseJSON, _ := json.Marshal(response)
+	routeDirect(ctx, &directMessage{playerID: player1ID, message: responseJSON, gameID: newGame.ID})
+	routeDirect(ctx, &directMessage{playerID: player2ID, message: responseJSON, gameID: newGame.ID})
 }
@@ -0,0 +1,824 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// memoryStore keeps all state in process. It mirrors the Redis semantics the
+// rest of the server relies on, including pub/sub fan-out, so a single
+// replica can run with STORE=memory.
+type memoryStore struct {
+	mu          sync.Mutex
+	games       map[string][]memoryEvent
+	gameExpiry  map[string]time.Time
+	updateSeqs  map[string]int64
+	updates     map[string][]GameUpdate
+	queue       []string
+	inQueue     map[string]bool
+	queuedAt    map[string]time.Time
+	inGame      map[string]string
+	names       map[string]string
+	boards      map[string]*memoryBoard
+	stats       map[string]*PlayerStats
+	tournaments map[string][]byte
+	standings   map[string][]byte
+	seasons     map[int][]byte
+	locks       map[string]memoryLock
+	presence    map[string]map[string]time.Time
+	deadlines   map[string]time.Time
+	reports     map[string][]byte
+	bans        map[string]PlayerBan
+	maintenance *Maintenance
+	// subscribers is keyed by channel name, as in Redis.
+	subscribers map[string]map[chan []byte]struct{}
+}
+
+// memoryEvent is a stream entry with a Redis-style "<ms>-<seq>" ID.
+type memoryEvent struct {
+	id   string
+	ms   int64
+	seq  int64
+	data []byte
+}
+
+// memoryLock is a named lock and the token of the holder that took it.
+type memoryLock struct {
+	token     string
+	expiresAt time.Time
+}
+
+type memoryBoard struct {
+	scores    map[string]float64
+	expiresAt time.Time
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		games:       make(map[string][]memoryEvent),
+		gameExpiry:  make(map[string]time.Time),
+		updateSeqs:  make(map[string]int64),
+		updates:     make(map[string][]GameUpdate),
+		inQueue:     make(map[string]bool),
+		queuedAt:    make(map[string]time.Time),
+		inGame:      make(map[string]string),
+		names:       make(map[string]string),
+		boards:      make(map[string]*memoryBoard),
+		stats:       make(map[string]*PlayerStats),
+		tournaments: make(map[string][]byte),
+		standings:   make(map[string][]byte),
+		seasons:     make(map[int][]byte),
+		locks:       make(map[string]memoryLock),
+		presence:    make(map[string]map[string]time.Time),
+		deadlines:   make(map[string]time.Time),
+		reports:     make(map[string][]byte),
+		bans:        make(map[string]PlayerBan),
+		subscribers: make(map[string]map[chan []byte]struct{}),
+	}
+}
+
+func (s *memoryStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+// expireGameLocked drops a game whose expiry has passed. s.mu must be held.
+func (s *memoryStore) expireGameLocked(gameID string) {
+	if expiresAt, ok := s.gameExpiry[gameID]; ok && time.Now().After(expiresAt) {
+		delete(s.games, gameID)
+		delete(s.gameExpiry, gameID)
+		delete(s.updateSeqs, gameID)
+		delete(s.updates, gameID)
+	}
+}
+
+func (s *memoryStore) AppendGameEvents(ctx context.Context, gameID string, afterID string, events []GameEvent) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expireGameLocked(gameID)
+
+	stream := s.games[gameID]
+	var last memoryEvent
+	if len(stream) > 0 {
+		last = stream[len(stream)-1]
+	}
+	if last.id != afterID {
+		return nil, ErrConflict
+	}
+
+	ids := make([]string, 0, len(events))
+	for _, event := range events {
+		jsonData, err := json.Marshal(event)
+		if err != nil {
+			return nil, err
+		}
+		entry := memoryEvent{ms: time.Now().UnixMilli(), data: jsonData}
+		if entry.ms <= last.ms {
+			entry.ms = last.ms
+			entry.seq = last.seq + 1
+		}
+		entry.id = fmt.Sprintf("%d-%d", entry.ms, entry.seq)
+		stream = append(stream, entry)
+		ids = append(ids, entry.id)
+		last = entry
+	}
+	s.games[gameID] = stream
+	return ids, nil
+}
+
+func (s *memoryStore) GameEvents(ctx context.Context, gameID string, afterID string) ([]GameEvent, error) {
+	s.mu.Lock()
+	s.expireGameLocked(gameID)
+	stream := s.games[gameID]
+	s.mu.Unlock()
+
+	start := 0
+	if afterID != "" {
+		for i, entry := range stream {
+			if entry.id == afterID {
+				start = i + 1
+				break
+			}
+		}
+	}
+	events := make([]GameEvent, 0, len(stream)-start)
+	for _, entry := range stream[start:] {
+		var event GameEvent
+		if err := json.Unmarshal(entry.data, &event); err != nil {
+			return nil, err
+		}
+		event.ID = entry.id
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func (s *memoryStore) ExpireGame(ctx context.Context, gameID string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.games[gameID]; ok {
+		s.gameExpiry[gameID] = time.Now().Add(ttl)
+	}
+	return nil
+}
+
+func (s *memoryStore) NextGameUpdateSeq(ctx context.Context, gameID string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expireGameLocked(gameID)
+	s.updateSeqs[gameID]++
+	return s.updateSeqs[gameID], nil
+}
+
+func (s *memoryStore) GameUpdateSeq(ctx context.Context, gameID string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expireGameLocked(gameID)
+	return s.updateSeqs[gameID], nil
+}
+
+func (s *memoryStore) BufferGameUpdate(ctx context.Context, gameID string, update GameUpdate, keep int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	buffered := append(s.updates[gameID], update)
+	sort.SliceStable(buffered, func(i, j int) bool { return buffered[i].Seq < buffered[j].Seq })
+	if int64(len(buffered)) > keep {
+		buffered = append([]GameUpdate(nil), buffered[int64(len(buffered))-keep:]...)
+	}
+	s.updates[gameID] = buffered
+	return nil
+}
+
+func (s *memoryStore) GameUpdatesSince(ctx context.Context, gameID string, afterSeq int64) ([]GameUpdate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expireGameLocked(gameID)
+	var updates []GameUpdate
+	for _, update := range s.updates[gameID] {
+		if update.Seq > afterSeq {
+			updates = append(updates, update)
+		}
+	}
+	return updates, nil
+}
+
+func (s *memoryStore) SetPresence(ctx context.Context, playerID string, replicaID string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	replicas, ok := s.presence[playerID]
+	if !ok {
+		replicas = make(map[string]time.Time)
+		s.presence[playerID] = replicas
+	}
+	replicas[replicaID] = time.Now().Add(ttl)
+	return nil
+}
+
+func (s *memoryStore) RemovePresence(ctx context.Context, playerID string, replicaID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.presence[playerID], replicaID)
+	if len(s.presence[playerID]) == 0 {
+		delete(s.presence, playerID)
+	}
+	return nil
+}
+
+func (s *memoryStore) PlayerReplicas(ctx context.Context, playerID string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var replicas []string
+	for replicaID, expiresAt := range s.presence[playerID] {
+		if now.Before(expiresAt) {
+			replicas = append(replicas, replicaID)
+		}
+	}
+	sort.Strings(replicas)
+	return replicas, nil
+}
+
+func (s *memoryStore) PublishToReplica(ctx context.Context, replicaID string, message []byte) error {
+	s.publish(replicaChannelPrefix+replicaID, message)
+	return nil
+}
+
+func (s *memoryStore) SubscribeReplica(ctx context.Context, replicaID string) (<-chan []byte, error) {
+	return s.subscribe(ctx, replicaChannelPrefix+replicaID), nil
+}
+
+func (s *memoryStore) PublishBroadcast(ctx context.Context, message []byte) error {
+	s.publish(broadcastChannel, message)
+	return nil
+}
+
+func (s *memoryStore) SubscribeBroadcast(ctx context.Context) (<-chan []byte, error) {
+	return s.subscribe(ctx, broadcastChannel), nil
+}
+
+// publish hands the message to the channel's subscribers. Like Redis
+// pub/sub it is fire-and-forget: the lock is released before delivery so a
+// slow subscriber cannot stall the store.
+func (s *memoryStore) publish(channel string, message []byte) {
+	s.mu.Lock()
+	subscribers := make([]chan []byte, 0, len(s.subscribers[channel]))
+	for ch := range s.subscribers[channel] {
+		subscribers = append(subscribers, ch)
+	}
+	s.mu.Unlock()
+
+	for _, ch := range subscribers {
+		select {
+		case ch <- message:
+		default:
+		}
+	}
+}
+
+func (s *memoryStore) subscribe(ctx context.Context, channel string) <-chan []byte {
+	ch := make(chan []byte, 256)
+	s.mu.Lock()
+	if s.subscribers[channel] == nil {
+		s.subscribers[channel] = make(map[chan []byte]struct{})
+	}
+	s.subscribers[channel][ch] = struct{}{}
+	s.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		s.mu.Lock()
+		delete(s.subscribers[channel], ch)
+		s.mu.Unlock()
+		close(ch)
+	}()
+	return ch
+}
+
+// The queue is kept in Redis list order: EnqueuePlayer pushes on the left
+// and PopQueuedPlayer pops from the right.
+func (s *memoryStore) EnqueuePlayer(ctx context.Context, playerID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.inQueue[playerID] {
+		return false, nil
+	}
+	s.inQueue[playerID] = true
+	s.queuedAt[playerID] = time.Now()
+	s.queue = append([]string{playerID}, s.queue...)
+	return true, nil
+}
+
+func (s *memoryStore) PopQueuedPlayer(ctx context.Context) (string, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.queue) == 0 {
+		return "", time.Time{}, ErrNotFound
+	}
+	playerID := s.queue[len(s.queue)-1]
+	s.queue = s.queue[:len(s.queue)-1]
+	queuedAt := s.queuedAt[playerID]
+	delete(s.inQueue, playerID)
+	delete(s.queuedAt, playerID)
+	return playerID, queuedAt, nil
+}
+
+func (s *memoryStore) RemoveFromQueue(ctx context.Context, playerID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	queue := s.queue[:0]
+	for _, queued := range s.queue {
+		if queued != playerID {
+			queue = append(queue, queued)
+		}
+	}
+	s.queue = queue
+	delete(s.inQueue, playerID)
+	delete(s.queuedAt, playerID)
+	return nil
+}
+
+func (s *memoryStore) IsQueued(ctx context.Context, playerID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inQueue[playerID], nil
+}
+
+func (s *memoryStore) QueueLength(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int64(len(s.queue)), nil
+}
+
+func (s *memoryStore) QueuedPlayers(ctx context.Context) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seen := make(map[string]bool)
+	var players []string
+	for playerID := range s.inQueue {
+		seen[playerID] = true
+		players = append(players, playerID)
+	}
+	for _, playerID := range s.queue {
+		if !seen[playerID] {
+			seen[playerID] = true
+			players = append(players, playerID)
+		}
+	}
+	return players, nil
+}
+
+func (s *memoryStore) MarkInGame(ctx context.Context, gameID string, playerIDs ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, playerID := range playerIDs {
+		s.inGame[playerID] = gameID
+	}
+	return nil
+}
+
+func (s *memoryStore) ClearInGame(ctx context.Context, playerIDs ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, playerID := range playerIDs {
+		delete(s.inGame, playerID)
+	}
+	return nil
+}
+
+func (s *memoryStore) IsInGame(ctx context.Context, playerID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.inGame[playerID]
+	return ok, nil
+}
+
+func (s *memoryStore) PlayersInGame(ctx context.Context) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	players := make([]string, 0, len(s.inGame))
+	for playerID := range s.inGame {
+		players = append(players, playerID)
+	}
+	sort.Strings(players)
+	return players, nil
+}
+
+func (s *memoryStore) PlayerGame(ctx context.Context, playerID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	gameID, ok := s.inGame[playerID]
+	if !ok || gameID == "" {
+		return "", ErrNotFound
+	}
+	return gameID, nil
+}
+
+func (s *memoryStore) BanPlayer(ctx context.Context, ban *PlayerBan) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bans[ban.PlayerID] = *ban
+	return nil
+}
+
+func (s *memoryStore) UnbanPlayer(ctx context.Context, playerID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.bans, playerID)
+	return nil
+}
+
+func (s *memoryStore) PlayerBan(ctx context.Context, playerID string) (*PlayerBan, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ban, ok := s.bans[playerID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if ban.expired(time.Now()) {
+		delete(s.bans, playerID)
+		return nil, ErrNotFound
+	}
+	return &ban, nil
+}
+
+func (s *memoryStore) Bans(ctx context.Context) ([]PlayerBan, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	bans := make([]PlayerBan, 0, len(s.bans))
+	for playerID, ban := range s.bans {
+		if ban.expired(now) {
+			delete(s.bans, playerID)
+			continue
+		}
+		bans = append(bans, ban)
+	}
+	return bans, nil
+}
+
+func (s *memoryStore) SetPlayerName(ctx context.Context, playerID string, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.names[playerID] = name
+	return nil
+}
+
+func (s *memoryStore) GetPlayerNames(ctx context.Context, playerIDs []string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, len(playerIDs))
+	for i, playerID := range playerIDs {
+		names[i] = s.names[playerID]
+	}
+	return names, nil
+}
+
+// board returns a leaderboard, dropping it first if it has expired. The
+// caller must hold s.mu.
+func (s *memoryStore) board(name string, create bool) *memoryBoard {
+	b, ok := s.boards[name]
+	if ok && !b.expiresAt.IsZero() && time.Now().After(b.expiresAt) {
+		delete(s.boards, name)
+		ok = false
+	}
+	if !ok && create {
+		b = &memoryBoard{scores: make(map[string]float64)}
+		s.boards[name] = b
+	}
+	return b
+}
+
+// ranked orders members like ZREVRANGE: highest score first, ties broken by
+// member in reverse lexicographic order.
+func (b *memoryBoard) ranked() []ScoredMember {
+	members := make([]ScoredMember, 0, len(b.scores))
+	for member, score := range b.scores {
+		members = append(members, ScoredMember{Member: member, Score: score})
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].Score != members[j].Score {
+			return members[i].Score > members[j].Score
+		}
+		return members[i].Member > members[j].Member
+	})
+	return members
+}
+
+func (s *memoryStore) IncrementScore(ctx context.Context, board string, playerID string, delta float64, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := s.board(board, true)
+	b.scores[playerID] += delta
+	if ttl > 0 {
+		b.expiresAt = time.Now().Add(ttl)
+	}
+	return nil
+}
+
+func (s *memoryStore) SetScore(ctx context.Context, board string, playerID string, score float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.board(board, true).scores[playerID] = score
+	return nil
+}
+
+func (s *memoryStore) RemoveScore(ctx context.Context, board string, playerID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if b := s.board(board, false); b != nil {
+		delete(b.scores, playerID)
+	}
+	return nil
+}
+
+func (s *memoryStore) Score(ctx context.Context, board string, playerID string) (float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := s.board(board, false)
+	if b == nil {
+		return 0, ErrNotFound
+	}
+	score, ok := b.scores[playerID]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	return score, nil
+}
+
+func (s *memoryStore) UpdateScores(ctx context.Context, board string, playerIDs []string, fn func(scores map[string]float64)) (map[string]float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := s.board(board, true)
+	scores := make(map[string]float64, len(playerIDs))
+	for _, playerID := range playerIDs {
+		if score, ok := b.scores[playerID]; ok {
+			scores[playerID] = score
+		}
+	}
+	fn(scores)
+	for _, playerID := range playerIDs {
+		if score, ok := scores[playerID]; ok {
+			b.scores[playerID] = score
+		}
+	}
+	return scores, nil
+}
+
+func (s *memoryStore) Rank(ctx context.Context, board string, playerID string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := s.board(board, false)
+	if b == nil {
+		return 0, ErrNotFound
+	}
+	for i, member := range b.ranked() {
+		if member.Member == playerID {
+			return int64(i), nil
+		}
+	}
+	return 0, ErrNotFound
+}
+
+func (s *memoryStore) RangeByRank(ctx context.Context, board string, start, stop int64) ([]ScoredMember, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := s.board(board, false)
+	if b == nil {
+		return []ScoredMember{}, nil
+	}
+	ranked := b.ranked()
+	total := int64(len(ranked))
+	if start < 0 {
+		start += total
+	}
+	if stop < 0 {
+		stop += total
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= total {
+		stop = total - 1
+	}
+	if start > stop {
+		return []ScoredMember{}, nil
+	}
+	return ranked[start : stop+1], nil
+}
+
+func (s *memoryStore) BoardSize(ctx context.Context, board string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := s.board(board, false)
+	if b == nil {
+		return 0, nil
+	}
+	return int64(len(b.scores)), nil
+}
+
+func (s *memoryStore) RecordPlayerResult(ctx context.Context, playerID string, outcome string, rating float64) (*PlayerStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats, ok := s.stats[playerID]
+	if !ok {
+		stats = &PlayerStats{}
+		s.stats[playerID] = stats
+	}
+	stats.Games++
+	switch outcome {
+	case OutcomeWin:
+		stats.Wins++
+		stats.Streak++
+	case OutcomeDraw:
+		stats.Draws++
+		stats.Streak = 0
+	default:
+		stats.Losses++
+		stats.Streak = 0
+	}
+	stats.Rating = rating
+	result := *stats
+	return &result, nil
+}
+
+func (s *memoryStore) SaveTournament(ctx context.Context, t *Tournament) error {
+	jsonData, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tournaments[t.ID] = jsonData
+	return nil
+}
+
+func (s *memoryStore) GetTournament(ctx context.Context, tournamentID string) (*Tournament, error) {
+	s.mu.Lock()
+	jsonData, ok := s.tournaments[tournamentID]
+	s.mu.Unlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	var t Tournament
+	if err := json.Unmarshal(jsonData, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (s *memoryStore) UpdateTournament(ctx context.Context, tournamentID string, fn func(t *Tournament) error) (*Tournament, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jsonData, ok := s.tournaments[tournamentID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	var t Tournament
+	if err := json.Unmarshal(jsonData, &t); err != nil {
+		return nil, err
+	}
+	if err := fn(&t); err != nil {
+		return nil, err
+	}
+	newData, err := json.Marshal(&t)
+	if err != nil {
+		return nil, err
+	}
+	s.tournaments[tournamentID] = newData
+	return &t, nil
+}
+
+func (s *memoryStore) SaveTournamentStandings(ctx context.Context, tournamentID string, standings []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.standings[tournamentID] = standings
+	return nil
+}
+
+func (s *memoryStore) GetSeasonArchive(ctx context.Context, season int) (*SeasonArchive, error) {
+	s.mu.Lock()
+	archiveJSON, ok := s.seasons[season]
+	s.mu.Unlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	var archive SeasonArchive
+	if err := json.Unmarshal(archiveJSON, &archive); err != nil {
+		return nil, err
+	}
+	return &archive, nil
+}
+
+func (s *memoryStore) ArchiveSeason(ctx context.Context, archive *SeasonArchive) error {
+	archiveJSON, err := json.Marshal(archive)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seasons[archive.Season] = archiveJSON
+	delete(s.boards, seasonLeaderboardKey(archive.Season))
+	return nil
+}
+
+func (s *memoryStore) ScheduleDeadline(ctx context.Context, member string, due time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deadlines[member] = due
+	return nil
+}
+
+func (s *memoryStore) CancelDeadline(ctx context.Context, member string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.deadlines, member)
+	return nil
+}
+
+func (s *memoryStore) DueDeadlines(ctx context.Context, now time.Time, limit int64) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var due []string
+	for member, at := range s.deadlines {
+		if !at.After(now) {
+			due = append(due, member)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if s.deadlines[due[i]].Equal(s.deadlines[due[j]]) {
+			return due[i] < due[j]
+		}
+		return s.deadlines[due[i]].Before(s.deadlines[due[j]])
+	})
+	if int64(len(due)) > limit {
+		due = due[:limit]
+	}
+	return due, nil
+}
+
+func (s *memoryStore) SetMaintenance(ctx context.Context, maintenance *Maintenance) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	copied := *maintenance
+	s.maintenance = &copied
+	return nil
+}
+
+func (s *memoryStore) ClearMaintenance(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maintenance = nil
+	return nil
+}
+
+func (s *memoryStore) Maintenance(ctx context.Context) (*Maintenance, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.maintenance == nil {
+		return nil, ErrNotFound
+	}
+	copied := *s.maintenance
+	return &copied, nil
+}
+
+func (s *memoryStore) SaveReport(ctx context.Context, name string, report []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reports[name] = append([]byte(nil), report...)
+	return nil
+}
+
+func (s *memoryStore) GetReport(ctx context.Context, name string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	report, ok := s.reports[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return report, nil
+}
+
+func (s *memoryStore) AcquireLock(ctx context.Context, name string, ttl time.Duration) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if lock, ok := s.locks[name]; ok && time.Now().Before(lock.expiresAt) {
+		return "", nil
+	}
+	token := uuid.NewString()
+	s.locks[name] = memoryLock{token: token, expiresAt: time.Now().Add(ttl)}
+	return token, nil
+}
+
+func (s *memoryStore) ReleaseLock(ctx context.Context, name string, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.locks[name].token == token {
+		delete(s.locks, name)
+	}
+	return nil
+}
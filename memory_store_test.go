@@ -0,0 +1,323 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreQueue(t *testing.T) {
+	tests := []struct {
+		name    string
+		enqueue []string
+		remove  []string
+		requeue []string
+		want    []string
+	}{
+		{name: "first in, first out", enqueue: []string{"a", "b", "c"}, want: []string{"a", "b", "c"}},
+		{name: "remove from the middle", enqueue: []string{"a", "b", "c"}, remove: []string{"b"}, want: []string{"a", "c"}},
+		{name: "remove a player who is not queued", enqueue: []string{"a"}, remove: []string{"z"}, want: []string{"a"}},
+		{name: "rejoin goes to the back", enqueue: []string{"a", "b"}, remove: []string{"a"}, requeue: []string{"a"}, want: []string{"b", "a"}},
+		{name: "enqueueing twice queues once", enqueue: []string{"a", "b"}, requeue: []string{"a"}, want: []string{"a", "b"}},
+		{name: "empty", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newMemoryStore()
+			for _, id := range tt.enqueue {
+				s.EnqueuePlayer(ctx, id)
+			}
+			for _, id := range tt.remove {
+				s.RemoveFromQueue(ctx, id)
+			}
+			for _, id := range tt.requeue {
+				s.EnqueuePlayer(ctx, id)
+			}
+
+			if length, _ := s.QueueLength(ctx); length != int64(len(tt.want)) {
+				t.Errorf("QueueLength = %d, want %d", length, len(tt.want))
+			}
+			for _, id := range tt.remove {
+				if queued, _ := s.IsQueued(ctx, id); queued && !slices.Contains(tt.want, id) {
+					t.Errorf("IsQueued(%q) = true after removal", id)
+				}
+			}
+
+			var got []string
+			for {
+				id, queuedAt, err := s.PopQueuedPlayer(ctx)
+				if errors.Is(err, ErrNotFound) {
+					break
+				}
+				if err != nil {
+					t.Fatalf("PopQueuedPlayer: %v", err)
+				}
+				if queuedAt.IsZero() {
+					t.Errorf("PopQueuedPlayer(%q) returned no enqueue time", id)
+				}
+				if queued, _ := s.IsQueued(ctx, id); queued {
+					t.Errorf("IsQueued(%q) = true after it was popped", id)
+				}
+				got = append(got, id)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pop order = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreInGame(t *testing.T) {
+	tests := []struct {
+		name      string
+		mark      map[string][]string
+		clear     []string
+		wantGames map[string]string
+	}{
+		{
+			name:      "both players marked",
+			mark:      map[string][]string{"g1": {"a", "b"}},
+			wantGames: map[string]string{"a": "g1", "b": "g1"},
+		},
+		{
+			name:      "clear releases only the given players",
+			mark:      map[string][]string{"g1": {"a", "b"}, "g2": {"c", "d"}},
+			clear:     []string{"a", "b"},
+			wantGames: map[string]string{"c": "g2", "d": "g2"},
+		},
+		{
+			name:      "clearing an idle player is harmless",
+			mark:      map[string][]string{"g1": {"a", "b"}},
+			clear:     []string{"z"},
+			wantGames: map[string]string{"a": "g1", "b": "g1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newMemoryStore()
+			for gameID, players := range tt.mark {
+				s.MarkInGame(ctx, gameID, players...)
+			}
+			s.ClearInGame(ctx, tt.clear...)
+
+			players, _ := s.PlayersInGame(ctx)
+			slices.Sort(players)
+			var want []string
+			for playerID := range tt.wantGames {
+				want = append(want, playerID)
+			}
+			slices.Sort(want)
+			if !reflect.DeepEqual(players, want) {
+				t.Errorf("PlayersInGame = %v, want %v", players, want)
+			}
+			for playerID, wantGame := range tt.wantGames {
+				if busy, _ := s.IsInGame(ctx, playerID); !busy {
+					t.Errorf("IsInGame(%q) = false", playerID)
+				}
+				if gameID, err := s.PlayerGame(ctx, playerID); err != nil || gameID != wantGame {
+					t.Errorf("PlayerGame(%q) = %q, %v; want %q", playerID, gameID, err, wantGame)
+				}
+			}
+			for _, playerID := range tt.clear {
+				if busy, _ := s.IsInGame(ctx, playerID); busy {
+					t.Errorf("IsInGame(%q) = true after ClearInGame", playerID)
+				}
+				if _, err := s.PlayerGame(ctx, playerID); !errors.Is(err, ErrNotFound) {
+					t.Errorf("PlayerGame(%q) error = %v, want ErrNotFound", playerID, err)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryStoreRangeByRank(t *testing.T) {
+	s := newMemoryStore()
+	// Ties are broken by member, descending, as Redis does for ZREVRANGE.
+	for member, score := range map[string]float64{"a": 3, "b": 5, "c": 1, "d": 5} {
+		s.SetScore(ctx, "board", member, score)
+	}
+
+	tests := []struct {
+		name        string
+		board       string
+		start, stop int64
+		want        []string
+	}{
+		{name: "everything", board: "board", start: 0, stop: -1, want: []string{"d", "b", "a", "c"}},
+		{name: "top two", board: "board", start: 0, stop: 1, want: []string{"d", "b"}},
+		{name: "middle", board: "board", start: 1, stop: 2, want: []string{"b", "a"}},
+		{name: "negative start and stop", board: "board", start: -2, stop: -1, want: []string{"a", "c"}},
+		{name: "negative stop", board: "board", start: 2, stop: -2, want: []string{"a"}},
+		{name: "stop past the end", board: "board", start: 3, stop: 10, want: []string{"c"}},
+		{name: "start after stop", board: "board", start: 3, stop: 1, want: []string{}},
+		{name: "start past the end", board: "board", start: 4, stop: -1, want: []string{}},
+		{name: "unknown board", board: "missing", start: 0, stop: -1, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			members, err := s.RangeByRank(ctx, tt.board, tt.start, tt.stop)
+			if err != nil {
+				t.Fatalf("RangeByRank: %v", err)
+			}
+			got := []string{}
+			for _, m := range members {
+				got = append(got, m.Member)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RangeByRank(%d, %d) = %v, want %v", tt.start, tt.stop, got, tt.want)
+			}
+		})
+	}
+
+	for member, want := range map[string]int64{"d": 0, "b": 1, "a": 2, "c": 3} {
+		if rank, err := s.Rank(ctx, "board", member); err != nil || rank != want {
+			t.Errorf("Rank(%q) = %d, %v; want %d", member, rank, err, want)
+		}
+	}
+	if _, err := s.Rank(ctx, "board", "z"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Rank of an unranked member: error = %v, want ErrNotFound", err)
+	}
+	if _, err := s.Rank(ctx, "missing", "a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Rank on an unknown board: error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestMemoryStoreUpdateScores(t *testing.T) {
+	s := newMemoryStore()
+	s.SetScore(ctx, "rating", "a", 1000)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.UpdateScores(ctx, "rating", []string{"a", "b"}, func(scores map[string]float64) {
+				scores["a"]++
+				if _, ok := scores["b"]; !ok {
+					scores["b"] = 100
+				}
+				scores["b"]--
+			})
+		}()
+	}
+	wg.Wait()
+
+	for member, want := range map[string]float64{"a": 1050, "b": 50} {
+		if got, err := s.Score(ctx, "rating", member); err != nil || got != want {
+			t.Errorf("Score(%q) = %v, %v; want %v", member, got, err, want)
+		}
+	}
+
+	scores, _ := s.UpdateScores(ctx, "rating", []string{"c"}, func(map[string]float64) {})
+	if _, ok := scores["c"]; ok {
+		t.Errorf("UpdateScores invented a score for an unranked player")
+	}
+	if _, err := s.Score(ctx, "rating", "c"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Score of an untouched player: error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestMemoryStoreLock(t *testing.T) {
+	s := newMemoryStore()
+
+	stale, err := s.AcquireLock(ctx, "deadlines", 10*time.Millisecond)
+	if err != nil || stale == "" {
+		t.Fatalf("AcquireLock = %q, %v; want a token", stale, err)
+	}
+	if token, _ := s.AcquireLock(ctx, "deadlines", time.Minute); token != "" {
+		t.Fatalf("AcquireLock while held returned %q, want \"\"", token)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	current, err := s.AcquireLock(ctx, "deadlines", time.Minute)
+	if err != nil || current == "" || current == stale {
+		t.Fatalf("AcquireLock after expiry = %q, %v; want a new token", current, err)
+	}
+
+	// The first holder overran its TTL; its release must not free the lock.
+	s.ReleaseLock(ctx, "deadlines", stale)
+	if token, _ := s.AcquireLock(ctx, "deadlines", time.Minute); token != "" {
+		t.Fatalf("stale release freed the lock: AcquireLock returned %q", token)
+	}
+
+	s.ReleaseLock(ctx, "deadlines", current)
+	if token, _ := s.AcquireLock(ctx, "deadlines", time.Minute); token == "" {
+		t.Errorf("AcquireLock after the holder released = \"\", want a token")
+	}
+}
+
+func TestMemoryStoreAppendGameEvents(t *testing.T) {
+	move := func(index int) GameEvent { return GameEvent{Type: EventMove, PlayerID: "a", Index: index} }
+
+	tests := []struct {
+		name string
+		// existing events are appended first; afterID then picks what the
+		// writer believes the log ends at.
+		existing int
+		afterID  func(ids []string) string
+		wantErr  error
+	}{
+		{name: "first append to an empty log", existing: 0, afterID: func([]string) string { return "" }},
+		{name: "empty log but writer expects events", existing: 0, afterID: func([]string) string { return "1-0" }, wantErr: ErrConflict},
+		{name: "append after the last event", existing: 2, afterID: func(ids []string) string { return ids[len(ids)-1] }},
+		{name: "stale writer", existing: 2, afterID: func(ids []string) string { return ids[0] }, wantErr: ErrConflict},
+		{name: "writer that missed every event", existing: 2, afterID: func([]string) string { return "" }, wantErr: ErrConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newMemoryStore()
+			var ids []string
+			for i := 0; i < tt.existing; i++ {
+				last := ""
+				if len(ids) > 0 {
+					last = ids[len(ids)-1]
+				}
+				added, err := s.AppendGameEvents(ctx, "g", last, []GameEvent{move(i)})
+				if err != nil {
+					t.Fatalf("setup append: %v", err)
+				}
+				ids = append(ids, added...)
+			}
+
+			added, err := s.AppendGameEvents(ctx, "g", tt.afterID(ids), []GameEvent{move(7), move(8)})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("AppendGameEvents error = %v, want %v", err, tt.wantErr)
+			}
+
+			events, _ := s.GameEvents(ctx, "g", "")
+			wantLen := tt.existing
+			if tt.wantErr == nil {
+				wantLen += 2
+				if len(added) != 2 {
+					t.Fatalf("AppendGameEvents returned %d IDs, want 2", len(added))
+				}
+			}
+			if len(events) != wantLen {
+				t.Fatalf("log has %d events, want %d", len(events), wantLen)
+			}
+			for i := 1; i < len(events); i++ {
+				if !streamIDLess(events[i-1].ID, events[i].ID) {
+					t.Errorf("event IDs not increasing: %q then %q", events[i-1].ID, events[i].ID)
+				}
+			}
+			if tt.wantErr == nil {
+				after, _ := s.GameEvents(ctx, "g", tt.afterID(ids))
+				if len(after) != 2 || after[0].ID != added[0] || after[1].Index != 8 {
+					t.Errorf("GameEvents after %q = %+v, want the two appended events", tt.afterID(ids), after)
+				}
+			}
+		})
+	}
+}
+
+// streamIDLess compares "<ms>-<seq>" IDs the way Redis orders them.
+func streamIDLess(a, b string) bool {
+	var aMs, aSeq, bMs, bSeq int64
+	fmt.Sscanf(a, "%d-%d", &aMs, &aSeq)
+	fmt.Sscanf(b, "%d-%d", &bMs, &bSeq)
+	return aMs < bMs || aMs == bMs && aSeq < bSeq
+}
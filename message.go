@@ -1,7 +1,10 @@
 package main
 
+import "time"
+
 type Message struct {
 	Type    string      `json:"type"`
+	Seq     int64       `json:"seq,omitempty"`
 	Payload interface{} `json:"payload"`
 }
 
@@ -16,6 +19,83 @@ type FindMatchPayload struct {
 }
 
 type ReconnectPayload struct {
+	PlayerID    string `json:"playerId"`
+	GameID      string `json:"gameId"`
+	LastEventID string `json:"lastEventId,omitempty"`
+}
+
+type LeaderboardPayload struct {
+	Metric   string `json:"metric,omitempty"`
+	Window   string `json:"window,omitempty"`
+	Period   string `json:"period,omitempty"`
+	Season   *int   `json:"season,omitempty"`
+	Offset   int64  `json:"offset,omitempty"`
+	Limit    int64  `json:"limit,omitempty"`
+	AroundMe int64  `json:"aroundMe,omitempty"`
+	PlayerID string `json:"playerId,omitempty"`
+}
+
+type CreateTournamentPayload struct {
+	Name     string `json:"name"`
+	Format   string `json:"format"`
+	Rounds   int    `json:"rounds"`
+	PlayerID string `json:"playerId,omitempty"`
+}
+
+type JoinTournamentPayload struct {
+	TournamentID string `json:"tournamentId"`
+	PlayerID     string `json:"playerId"`
+	PlayerName   string `json:"playerName"`
+}
+
+type TournamentPayload struct {
+	TournamentID string `json:"tournamentId"`
+	PlayerID     string `json:"playerId,omitempty"`
+}
+
+type HistoryPayload struct {
+	PlayerID string `json:"playerId,omitempty"`
+	Limit    int    `json:"limit,omitempty"`
+	Offset   int    `json:"offset,omitempty"`
+}
+
+type GameEventsPayload struct {
+	PlayerID     string `json:"playerId,omitempty"`
+	GameID       string `json:"gameId"`
+	AfterEventID string `json:"afterEventId,omitempty"`
+}
+
+type ResumePayload struct {
 	PlayerID string `json:"playerId"`
 	GameID   string `json:"gameId"`
+	LastSeq  int64  `json:"lastSeq"`
+}
+
+type ServerShutdownPayload struct {
+	Reason       string `json:"reason"`
+	RetryAfterMs int64  `json:"retryAfterMs"`
+}
+
+// ErrorPayload is sent as an "error" message when the server refuses a
+// request outright.
+type ErrorPayload struct {
+	Code         string `json:"code"`
+	Message      string `json:"message"`
+	MessageType  string `json:"messageType,omitempty"`
+	RetryAfterMs int64  `json:"retryAfterMs,omitempty"`
+}
+
+// Kinds of server_announcement.
+const (
+	AnnouncementMessage          = "announcement"
+	AnnouncementMaintenanceStart = "maintenance_started"
+	AnnouncementMaintenanceEnd   = "maintenance_ended"
+)
+
+// ServerAnnouncementPayload is broadcast to every connected client.
+type ServerAnnouncementPayload struct {
+	Kind    string     `json:"kind"`
+	Message string     `json:"message"`
+	SentAt  time.Time  `json:"sentAt"`
+	EndsAt  *time.Time `json:"endsAt,omitempty"`
 }
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const metricsNamespace = "tictactoe"
+
+var (
+	connectedClients = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "connected_clients",
+		Help:      "WebSocket connections registered with this replica's hub.",
+	})
+	queueLengthGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "matchmaking_queue_length",
+		Help:      "Players waiting in the shared matchmaking queue, as last seen by this replica.",
+	})
+	queueWait = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "matchmaking_wait_seconds",
+		Help:      "Time matched players spent in the queue.",
+		Buckets:   []float64{1, 3, 5, 10, 20, 30, 60, 120, 300},
+	})
+	gamesCreated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "games_created_total",
+		Help:      "Games created, by source (matchmaking or tournament).",
+	}, []string{"source"})
+	gamesFinished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "games_finished_total",
+		Help:      "Games finished, by final status.",
+	}, []string{"outcome"})
+	moveDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "move_handling_seconds",
+		Help:      "Time spent handling a move message, including rejected moves.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+	})
+	messagesForwarded = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "pubsub_messages_forwarded_total",
+		Help:      "Messages received on this replica's channel and handed to the hub.",
+	})
+	originsRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "origin_rejected_total",
+		Help:      "Requests from origins outside the allowlist, by transport (websocket or http).",
+	}, []string{"transport"})
+	rateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "rate_limited_messages_total",
+		Help:      "Inbound messages refused by the rate limiter, by message class.",
+	}, []string{"class"})
+	rateLimitDisconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "rate_limit_disconnects_total",
+		Help:      "Connections closed for repeatedly exceeding the rate limit.",
+	})
+	ipConnectionsRejected = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "ip_connections_rejected_total",
+		Help:      "WebSocket upgrades refused because the client IP already had the maximum number of connections.",
+	})
+	maintenanceMode = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "maintenance_mode",
+		Help:      "1 while this replica sees maintenance mode switched on.",
+	})
+	tlsCertExpiry = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "tls_certificate_expiry_timestamp_seconds",
+		Help:      "Expiry of the certificate being served, as a Unix timestamp.",
+	})
+	tlsReloadErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "tls_reload_errors_total",
+		Help:      "Changed certificate files that could not be loaded.",
+	})
+	droppedSends = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "dropped_sends_total",
+		Help:      "Messages dropped, and connections closed, because a client's send buffer was full.",
+	})
+	redisDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "redis_command_duration_seconds",
+		Help:      "Latency of Redis commands and pipelines.",
+		Buckets:   prometheus.ExponentialBuckets(0.0002, 2, 14),
+	}, []string{"command"})
+	redisErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "redis_errors_total",
+		Help:      "Redis commands that failed, excluding missing keys.",
+	}, []string{"command"})
+)
+
+type redisStartKey struct{}
+
+// redisMetricsHook times every command and pipeline sent through the
+// client. Pipelines are reported under the "pipeline" command label.
+type redisMetricsHook struct{}
+
+func (redisMetricsHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, redisStartKey{}, time.Now()), nil
+}
+
+func (redisMetricsHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	observeRedis(ctx, cmd.Name(), cmd.Err())
+	return nil
+}
+
+func (redisMetricsHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, redisStartKey{}, time.Now()), nil
+}
+
+func (redisMetricsHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if cmdErr := cmd.Err(); cmdErr != nil && err == nil {
+			err = cmdErr
+		}
+	}
+	observeRedis(ctx, "pipeline", err)
+	return nil
+}
+
+func observeRedis(ctx context.Context, command string, err error) {
+	if start, ok := ctx.Value(redisStartKey{}).(time.Time); ok {
+		redisDuration.WithLabelValues(command).Observe(time.Since(start).Seconds())
+	}
+	if err != nil && !errors.Is(err, redis.Nil) && !errors.Is(err, redis.TxFailedErr) {
+		redisErrors.WithLabelValues(command).Inc()
+	}
+}
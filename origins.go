@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var originLog = newLogger("origins")
+
+// originPolicy decides which browser origins may open WebSockets and read
+// HTTP responses. Entries are exact origins ("https://play.example.com"),
+// wildcard subdomains ("https://*.example.com", which does not match the
+// bare domain) or "*" for any origin. An empty policy admits only requests
+// without an Origin header.
+type originPolicy struct {
+	any       bool
+	exact     map[string]bool
+	wildcards []originWildcard
+}
+
+// originWildcard matches "<scheme>://<one or more labels><suffix>".
+type originWildcard struct {
+	scheme string
+	suffix string
+}
+
+func newOriginPolicy(origins []string) (*originPolicy, error) {
+	p := &originPolicy{exact: make(map[string]bool)}
+	for _, origin := range origins {
+		origin = normalizeOrigin(origin)
+		if origin == "*" {
+			p.any = true
+			continue
+		}
+		// Parse with the wildcard swapped for a label so url.Parse accepts it.
+		u, err := url.Parse(strings.Replace(origin, "://*.", "://x.", 1))
+		if err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" || u.RawQuery != "" || u.User != nil {
+			return nil, fmt.Errorf("%q is not an origin like https://example.com", origin)
+		}
+		if strings.Contains(u.Host, "*") {
+			return nil, fmt.Errorf("%q: a wildcard may only replace the leftmost label", origin)
+		}
+		if scheme, host, ok := strings.Cut(origin, "://*."); ok {
+			p.wildcards = append(p.wildcards, originWildcard{scheme: scheme, suffix: "." + host})
+			continue
+		}
+		p.exact[origin] = true
+	}
+	return p, nil
+}
+
+func normalizeOrigin(origin string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(origin)), "/")
+}
+
+func (p *originPolicy) allowed(origin string) bool {
+	if p.any {
+		return true
+	}
+	origin = normalizeOrigin(origin)
+	if p.exact[origin] {
+		return true
+	}
+	for _, w := range p.wildcards {
+		scheme, host, ok := strings.Cut(origin, "://")
+		if !ok || scheme != w.scheme {
+			continue
+		}
+		labels, ok := strings.CutSuffix(host, w.suffix)
+		if ok && labels != "" && !strings.ContainsAny(labels, ":/@") {
+			return true
+		}
+	}
+	return false
+}
+
+// checkWebSocket is the upgrader's CheckOrigin. Requests without an Origin
+// header come from non-browser clients, which cannot be embedded in a page,
+// and are allowed.
+func (p *originPolicy) checkWebSocket(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || p.allowed(origin) {
+		return true
+	}
+	originsRejected.WithLabelValues("websocket").Inc()
+	originLog.Info("rejected websocket origin", "origin", origin, "remote_addr", r.RemoteAddr)
+	return false
+}
+
+// checkHTTP is the CORS AllowOriginFunc. A rejected origin gets no CORS
+// headers, so the browser withholds the response from the calling page.
+// Requests without an Origin header are not cross-origin and need none.
+func (p *originPolicy) checkHTTP(origin string) bool {
+	if origin == "" {
+		return false
+	}
+	if p.allowed(origin) {
+		return true
+	}
+	originsRejected.WithLabelValues("http").Inc()
+	originLog.Info("rejected http origin", "origin", origin)
+	return false
+}
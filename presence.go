@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const presenceTTL = 60 * time.Second
+const presenceRefreshInterval = 20 * time.Second
+
+// replicaID names this process in the presence registry and is the address
+// other replicas publish direct messages to.
+var replicaID string
+
+// routedMessage is the envelope replicas exchange on their direct channels.
+type routedMessage struct {
+	PlayerID string          `json:"playerId"`
+	GameID   string          `json:"gameId,omitempty"`
+	Seq      int64           `json:"seq,omitempty"`
+	Message  json.RawMessage `json:"message"`
+	// Disconnect asks the receiving replica to close the player's
+	// connections after delivering Message.
+	Disconnect bool `json:"disconnect,omitempty"`
+	// Trace carries the sender's trace context so delivery joins its trace.
+	Trace map[string]string `json:"trace,omitempty"`
+}
+
+var presenceLog = newLogger("presence")
+
+// initPresence sets this replica's identity, generating one if id is empty.
+func initPresence(id string) {
+	replicaID = id
+	if replicaID == "" {
+		replicaID = uuid.NewString()
+	}
+	presenceLog.Info("replica started", "replica_id", replicaID)
+}
+
+func announcePresence(playerID string) {
+	if err := store.SetPresence(ctx, playerID, replicaID, presenceTTL); err != nil {
+		presenceLog.Error("error registering presence", "player_id", playerID, "error", err)
+	}
+}
+
+func withdrawPresence(playerID string) {
+	if err := store.RemovePresence(ctx, playerID, replicaID); err != nil {
+		presenceLog.Error("error removing presence", "player_id", playerID, "error", err)
+	}
+}
+
+// presenceChange is a player gaining their first or losing their last
+// connection to this replica.
+type presenceChange struct {
+	playerID string
+	online   bool
+	// applied is closed once the change has reached the store.
+	applied chan struct{}
+	// then, if set, runs once the change is applied.
+	then func()
+}
+
+// presenceQueue carries the hub's presence changes to a worker, so the hub
+// goroutine never waits on the store. Changes are applied in order, so a
+// player who drops and reconnects is withdrawn before being announced again.
+type presenceQueue struct {
+	mu      sync.Mutex
+	pending []*presenceChange
+	wake    chan struct{}
+	// flushing serialises flush between the worker and shutdown.
+	flushing sync.Mutex
+}
+
+func newPresenceQueue() *presenceQueue {
+	return &presenceQueue{wake: make(chan struct{}, 1)}
+}
+
+// push queues a change and returns its applied channel. It never blocks.
+func (q *presenceQueue) push(change *presenceChange) <-chan struct{} {
+	change.applied = make(chan struct{})
+	q.mu.Lock()
+	q.pending = append(q.pending, change)
+	q.mu.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return change.applied
+}
+
+// run applies changes as they arrive. It never returns.
+func (q *presenceQueue) run() {
+	for range q.wake {
+		q.flush()
+	}
+}
+
+// flush applies every pending change. A player who left is withdrawn from
+// the registry and the matchmaking queue.
+func (q *presenceQueue) flush() {
+	q.flushing.Lock()
+	defer q.flushing.Unlock()
+	q.mu.Lock()
+	batch := q.pending
+	q.pending = nil
+	q.mu.Unlock()
+
+	for _, change := range batch {
+		if change.online {
+			announcePresence(change.playerID)
+		} else {
+			withdrawPresence(change.playerID)
+			if err := store.RemoveFromQueue(ctx, change.playerID); err != nil {
+				presenceLog.Error("error removing disconnected player from matchmaking queue", "player_id", change.playerID, "error", err)
+			} else {
+				presenceLog.Info("player removed from matchmaking queue after disconnect", "player_id", change.playerID)
+			}
+		}
+		close(change.applied)
+		if change.then != nil {
+			go change.then()
+		}
+	}
+}
+
+// startPresenceRefresh keeps this replica's entries alive. If the process
+// dies, its entries lapse after presenceTTL and messages stop being routed
+// to it.
+func startPresenceRefresh(hub *Hub) {
+	ticker := time.NewTicker(presenceRefreshInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		for _, playerID := range hub.connectedPlayers() {
+			announcePresence(playerID)
+		}
+	}
+}
+
+func isOnline(ctx context.Context, playerID string) bool {
+	replicas, err := store.PlayerReplicas(ctx, playerID)
+	return err == nil && len(replicas) > 0
+}
+
+// routeDirect publishes a message to every replica holding a connection
+// for dm.playerID. Players with no connections are skipped.
+func routeDirect(ctx context.Context, dm *directMessage) error {
+	replicas, err := store.PlayerReplicas(ctx, dm.playerID)
+	if err != nil {
+		presenceLog.Error("error looking up player replicas", "player_id", dm.playerID, "error", err)
+		return err
+	}
+	if len(replicas) == 0 {
+		presenceLog.Debug("player not connected to any replica", "player_id", dm.playerID, "game_id", dm.gameID)
+		return nil
+	}
+	data, err := json.Marshal(routedMessage{PlayerID: dm.playerID, GameID: dm.gameID, Seq: dm.seq, Message: dm.message, Disconnect: dm.disconnect, Trace: injectTrace(ctx)})
+	if err != nil {
+		return err
+	}
+	for _, replica := range replicas {
+		if err := store.PublishToReplica(ctx, replica, data); err != nil {
+			presenceLog.Error("error publishing to replica", "replica_id", replica, "player_id", dm.playerID, "error", err)
+		}
+	}
+	return nil
+}
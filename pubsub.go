@@ -3,28 +3,57 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"log"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func subscribeToGameUpdates(ctx context.Context, hub *Hub) {
-	log.Println("[PUBSUB] Subscribing to game update channels (game:*)")
-	pubsub := rdb.PSubscribe(ctx, "game:*")
-	defer pubsub.Close()
+var pubsubLog = newLogger("pubsub")
+
+// subscribeToDirectMessages delivers messages routed to this replica to
+// the local connections of their recipients.
+func subscribeToDirectMessages(ctx context.Context, hub *Hub) {
+	pubsubLog.Info("subscribing to direct messages", "replica_id", replicaID)
+	messages, err := store.SubscribeReplica(ctx, replicaID)
+	if err != nil {
+		pubsubLog.Error("error subscribing to direct messages", "replica_id", replicaID, "error", err)
+		return
+	}
+	subscribed.Store(true)
+	defer subscribed.Store(false)
 
-	ch := pubsub.Channel()
-	for msg := range ch {
-		log.Printf("[PUBSUB] Received message from Redis on channel %s", msg.Channel)
-		var gameUpdate Message
-		if err := json.Unmarshal([]byte(msg.Payload), &gameUpdate); err != nil {
-			log.Printf("[PUBSUB] Error unmarshalling game update: %v", err)
+	for payload := range messages {
+		var routed routedMessage
+		if err := json.Unmarshal(payload, &routed); err != nil {
+			pubsubLog.Warn("error unmarshalling routed message", "error", err)
 			continue
 		}
+		pubsubLog.Debug("received routed message", "player_id", routed.PlayerID, "game_id", routed.GameID, "seq", routed.Seq)
+		_, span := tracer.Start(extractTrace(routed.Trace), "pubsub.deliver",
+			trace.WithSpanKind(trace.SpanKindConsumer),
+			trace.WithAttributes(
+				attribute.String("player.id", routed.PlayerID),
+				attribute.String("game.id", routed.GameID),
+				attribute.Int64("game.update_seq", routed.Seq),
+			),
+		)
+		messagesForwarded.Inc()
+		hub.direct <- &directMessage{playerID: routed.PlayerID, message: routed.Message, gameID: routed.GameID, seq: routed.Seq, disconnect: routed.Disconnect}
+		span.End()
+	}
+	pubsubLog.Error("direct message subscription ended", "replica_id", replicaID)
+}
 
-		gameData, _ := json.Marshal(gameUpdate.Payload)
-		var game Game
-		json.Unmarshal(gameData, &game)
-
-		hub.direct <- &directMessage{playerID: game.PlayerX, message: []byte(msg.Payload)}
-		hub.direct <- &directMessage{playerID: game.PlayerO, message: []byte(msg.Payload)}
+// subscribeToBroadcasts delivers messages published to every replica, such
+// as admin announcements, to all local connections.
+func subscribeToBroadcasts(ctx context.Context, hub *Hub) {
+	messages, err := store.SubscribeBroadcast(ctx)
+	if err != nil {
+		pubsubLog.Error("error subscribing to broadcasts", "error", err)
+		return
+	}
+	for message := range messages {
+		hub.broadcast <- message
 	}
+	pubsubLog.Error("broadcast subscription ended")
 }
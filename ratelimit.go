@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Message classes with their own token buckets. Every other message type
+// shares limitDefault.
+const (
+	limitMove        = "move"
+	limitFindMatch   = "find_match"
+	limitLeaderboard = "get_leaderboard"
+	limitDefault     = "default"
+)
+
+// playerLimiterIdle is how long a player's buckets are kept after their
+// last message. By then they have refilled, so dropping them is harmless.
+const playerLimiterIdle = 5 * time.Minute
+
+func limitClass(messageType string) string {
+	switch messageType {
+	case limitMove, limitFindMatch, limitLeaderboard:
+		return messageType
+	}
+	return limitDefault
+}
+
+// messageLimiter holds one token bucket per message class.
+type messageLimiter map[string]*rate.Limiter
+
+func newMessageLimiter(cfg RateLimitConfig) messageLimiter {
+	bucket := func(burst int, interval time.Duration) *rate.Limiter {
+		if interval == 0 {
+			return rate.NewLimiter(rate.Inf, burst)
+		}
+		return rate.NewLimiter(rate.Every(interval), burst)
+	}
+	return messageLimiter{
+		limitMove:        bucket(cfg.MoveBurst, cfg.MoveInterval),
+		limitFindMatch:   bucket(cfg.FindMatchBurst, cfg.FindMatchInterval),
+		limitLeaderboard: bucket(cfg.LeaderboardBurst, cfg.LeaderboardInterval),
+		limitDefault:     bucket(cfg.DefaultBurst, cfg.DefaultInterval),
+	}
+}
+
+// takeToken spends a token for class from every limiter, or from none if
+// any of them is empty, in which case it returns how long to wait.
+func takeToken(class string, limiters ...messageLimiter) time.Duration {
+	now := time.Now()
+	var taken []*rate.Reservation
+	for _, limiter := range limiters {
+		r := limiter[class].ReserveN(now, 1)
+		if wait := r.DelayFrom(now); wait > 0 {
+			r.CancelAt(now)
+			for _, t := range taken {
+				t.CancelAt(now)
+			}
+			return wait
+		}
+		taken = append(taken, r)
+	}
+	return 0
+}
+
+// rateLimits holds the state shared by the connections of this replica:
+// per-player buckets, so opening more sockets does not buy a player more
+// messages, and the number of connections from each client IP.
+type rateLimits struct {
+	config RateLimitConfig
+
+	mu        sync.Mutex
+	players   map[string]*playerLimiter
+	lastSweep time.Time
+	ips       map[string]int
+}
+
+type playerLimiter struct {
+	limiter  messageLimiter
+	lastUsed time.Time
+}
+
+func newRateLimits(cfg RateLimitConfig) *rateLimits {
+	return &rateLimits{
+		config:    cfg,
+		players:   make(map[string]*playerLimiter),
+		lastSweep: time.Now(),
+		ips:       make(map[string]int),
+	}
+}
+
+// player returns playerID's buckets, dropping those of players who have
+// been idle for playerLimiterIdle.
+func (l *rateLimits) player(playerID string) messageLimiter {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) > playerLimiterIdle {
+		for id, p := range l.players {
+			if now.Sub(p.lastUsed) > playerLimiterIdle {
+				delete(l.players, id)
+			}
+		}
+		l.lastSweep = now
+	}
+	p, ok := l.players[playerID]
+	if !ok {
+		p = &playerLimiter{limiter: newMessageLimiter(l.config)}
+		l.players[playerID] = p
+	}
+	p.lastUsed = now
+	return p.limiter
+}
+
+// acquireConnection counts a new connection from ip, refusing it if the IP
+// already has MaxConnectionsPerIP.
+func (l *rateLimits) acquireConnection(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if limit := l.config.MaxConnectionsPerIP; limit > 0 && l.ips[ip] >= limit {
+		return false
+	}
+	l.ips[ip]++
+	return true
+}
+
+func (l *rateLimits) releaseConnection(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.ips[ip] <= 1 {
+		delete(l.ips, ip)
+		return
+	}
+	l.ips[ip]--
+}
+
+// clientIP is the address connection limits are counted against. Behind a
+// proxy every connection shares the proxy's address, so with
+// trustForwardedFor the first X-Forwarded-For entry is used instead.
+func clientIP(r *http.Request, trustForwardedFor bool) string {
+	if trustForwardedFor {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			return strings.TrimSpace(first)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// allowMessage spends a token for messageType from the connection's
+// buckets and, once the connection has identified, the player's. A refused
+// message is answered with a rate_limited error, and disconnect is set once
+// the connection has been refused more than MaxViolations times within
+// ViolationWindow.
+func (c *Client) allowMessage(messageType string) (ok bool, disconnect bool) {
+	limiters := []messageLimiter{c.limiter}
+	if c.PlayerID != "" {
+		limiters = append(limiters, c.hub.limits.player(c.PlayerID))
+	}
+	class := limitClass(messageType)
+	wait := takeToken(class, limiters...)
+	if wait == 0 {
+		return true, false
+	}
+
+	rateLimited.WithLabelValues(class).Inc()
+	c.logger().Debug("message rate limited", "type", messageType, "retry_after", wait.String())
+	c.sendError(ErrorPayload{
+		Code:         "rate_limited",
+		Message:      "too many " + messageType + " messages",
+		MessageType:  messageType,
+		RetryAfterMs: max(wait.Milliseconds(), 1),
+	})
+
+	cfg := c.hub.config.RateLimit
+	now := time.Now()
+	if now.Sub(c.violationsSince) > cfg.ViolationWindow {
+		c.violationsSince = now
+		c.violations = 0
+	}
+	c.violations++
+	return false, cfg.MaxViolations > 0 && c.violations > cfg.MaxViolations
+}
+
+// sendError tells the client a request was refused. It does not block: a
+// client that is flooding the server is not owed every error.
+func (c *Client) sendError(payload ErrorPayload) {
+	data, _ := json.Marshal(Message{Type: "error", Payload: payload})
+	select {
+	case c.send <- data:
+	case <-c.done:
+	default:
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+var reaperLog = newLogger("reaper")
+
+const reaperLockName = "reaper"
+const reaperReportName = "reaper"
+
+// ReaperReport lists what one reaper pass repaired.
+type ReaperReport struct {
+	Replica         string    `json:"replica"`
+	StartedAt       time.Time `json:"startedAt"`
+	DurationMs      int64     `json:"durationMs"`
+	PlayersChecked  int       `json:"playersChecked"`
+	GamesChecked    int       `json:"gamesChecked"`
+	ReleasedPlayers []string  `json:"releasedPlayers"`
+	DequeuedPlayers []string  `json:"dequeuedPlayers"`
+	ForfeitsStarted []string  `json:"forfeitsStarted"`
+	ForfeitsForced  []string  `json:"forfeitsForced"`
+	AbandonedGames  []string  `json:"abandonedGames"`
+	// Suspects are the entries that looked wrong on this pass. The next
+	// pass repairs those that still do, whichever replica runs it.
+	Suspects []string `json:"suspects"`
+}
+
+// startReaper reconciles players_in_game, the matchmaking queue and live
+// games against presence until shutdown is cancelled. Entries are only
+// repaired once they have looked wrong on two passes in a row, so state
+// that is briefly inconsistent while a match is being created is left
+// alone. The suspects are kept in the stored report, so a pass on another
+// replica picks up where the last one left off.
+func startReaper(shutdown context.Context, hub *Hub) {
+	reaperLog.Info("reaper started")
+	interval := hub.config.Reaper.Interval
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-shutdown.Done():
+			reaperLog.Info("reaper stopped")
+			return
+		case <-ticker.C:
+		}
+
+		// The lock is left to expire, so one pass runs per interval.
+		token, err := store.AcquireLock(ctx, reaperLockName, interval)
+		if err != nil || token == "" {
+			continue
+		}
+		reap(hub)
+	}
+}
+
+// lastSuspects returns the entries the previous pass flagged. A report
+// older than two intervals is not "the pass before", so it is ignored.
+func lastSuspects(hub *Hub) map[string]bool {
+	suspects := make(map[string]bool)
+	data, err := store.GetReport(ctx, reaperReportName)
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			reaperLog.Error("error loading previous report", "error", err)
+		}
+		return suspects
+	}
+	var previous ReaperReport
+	if err := json.Unmarshal(data, &previous); err != nil {
+		reaperLog.Warn("ignoring unreadable previous report", "error", err)
+		return suspects
+	}
+	if time.Since(previous.StartedAt) > 2*hub.config.Reaper.Interval {
+		return suspects
+	}
+	for _, key := range previous.Suspects {
+		suspects[key] = true
+	}
+	return suspects
+}
+
+// reap runs one pass and stores its report, including the entries to
+// recheck on the next pass.
+func reap(hub *Hub) {
+	suspects := lastSuspects(hub)
+	report := &ReaperReport{
+		Replica:         replicaID,
+		StartedAt:       time.Now().UTC(),
+		ReleasedPlayers: []string{},
+		DequeuedPlayers: []string{},
+		ForfeitsStarted: []string{},
+		ForfeitsForced:  []string{},
+		AbandonedGames:  []string{},
+		Suspects:        []string{},
+	}
+	confirmed := func(key string) bool {
+		if suspects[key] {
+			return true
+		}
+		report.Suspects = append(report.Suspects, key)
+		return false
+	}
+
+	games := make(map[string]bool)
+	players, err := store.PlayersInGame(ctx)
+	if err != nil {
+		reaperLog.Error("error listing players in game", "error", err)
+	}
+	report.PlayersChecked = len(players)
+	for _, playerID := range players {
+		gameID, err := store.PlayerGame(ctx, playerID)
+		if err == nil {
+			var game *Game
+			if game, err = getGame(ctx, gameID); err == nil && !game.isOver() {
+				games[gameID] = true
+				continue
+			}
+		}
+		if !errors.Is(err, ErrNotFound) && err != nil {
+			continue
+		}
+		if confirmed("in_game:" + playerID) {
+			store.ClearInGame(ctx, playerID)
+			report.ReleasedPlayers = append(report.ReleasedPlayers, playerID)
+		}
+	}
+
+	queued, err := store.QueuedPlayers(ctx)
+	if err != nil {
+		reaperLog.Error("error listing queued players", "error", err)
+	}
+	for _, playerID := range queued {
+		busy, _ := store.IsInGame(ctx, playerID)
+		if (busy || !isOnline(ctx, playerID)) && confirmed("queue:"+playerID) {
+			store.RemoveFromQueue(ctx, playerID)
+			report.DequeuedPlayers = append(report.DequeuedPlayers, playerID)
+		}
+	}
+
+	report.GamesChecked = len(games)
+	for gameID := range games {
+		reapGame(hub, gameID, report)
+	}
+
+	report.DurationMs = time.Since(report.StartedAt).Milliseconds()
+	fixed := len(report.ReleasedPlayers) + len(report.DequeuedPlayers) + len(report.ForfeitsStarted) +
+		len(report.ForfeitsForced) + len(report.AbandonedGames)
+	reaperLog.Info("reaper pass complete", "players_checked", report.PlayersChecked, "games_checked", report.GamesChecked, "fixed", fixed)
+	if data, err := json.Marshal(report); err == nil {
+		store.SaveReport(ctx, reaperReportName, data)
+	}
+}
+
+// reapGame resolves a live game nobody is playing any more. A game with
+// one player gone starts that player's forfeit timer, one with both gone
+// is abandoned, and a forfeit whose deadline was lost is applied directly.
+func reapGame(hub *Hub, gameID string, report *ReaperReport) {
+	events, err := store.GameEvents(ctx, gameID, "")
+	if err != nil || len(events) == 0 {
+		return
+	}
+	game := foldGameEvents(events)
+	idle := time.Since(events[len(events)-1].At)
+
+	switch game.Status {
+	case StatusPlaying:
+		if idle < hub.config.Reaper.Grace {
+			return
+		}
+		onlineX, onlineO := isOnline(ctx, game.PlayerX), isOnline(ctx, game.PlayerO)
+		switch {
+		case !onlineX && !onlineO:
+			reaperLog.Warn("both players left game, abandoning it", "game_id", gameID)
+			if err := recordGameEvents(ctx, hub, game, GameEvent{Type: EventAbandoned}, GameEvent{Type: EventFinished}); err != nil {
+				return
+			}
+			publishGameUpdate(ctx, hub, game)
+			finishGame(ctx, hub, game)
+			report.AbandonedGames = append(report.AbandonedGames, gameID)
+		case !onlineX, !onlineO:
+			playerID := game.PlayerX
+			if onlineX {
+				playerID = game.PlayerO
+			}
+			started, err := handleGameDisconnect(hub, playerID, gameID)
+			if err != nil {
+				reaperLog.Error("error starting forfeit timer", "player_id", playerID, "game_id", gameID, "error", err)
+			} else if started {
+				report.ForfeitsStarted = append(report.ForfeitsStarted, gameID)
+			}
+		}
+
+	case StatusDisconnectedX, StatusDisconnectedO:
+		if idle < hub.config.Game.ForfeitTimeout+hub.config.Reaper.Grace {
+			return
+		}
+		playerID := game.PlayerX
+		if game.Status == StatusDisconnectedO {
+			playerID = game.PlayerO
+		}
+		reaperLog.Warn("overdue forfeit, applying it", "player_id", playerID, "game_id", gameID)
+		// On failure the persisted deadline, if any, stays to retry it.
+		if err := handleForfeitDeadline(hub, []string{gameID, playerID}); err != nil {
+			reaperLog.Error("error applying overdue forfeit", "player_id", playerID, "game_id", gameID, "error", err)
+			return
+		}
+		cancelDeadline(DeadlineForfeit, gameID, playerID)
+		report.ForfeitsForced = append(report.ForfeitsForced, gameID)
+	}
+}
+
+// handleAdminReaperReport serves the most recent reaper report from any
+// replica.
+func handleAdminReaperReport(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	report, err := store.GetReport(r.Context(), reaperReportName)
+	if errors.Is(err, ErrNotFound) {
+		http.Error(w, "no reaper pass has run yet", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		reaperLog.Error("error loading report", "error", err)
+		http.Error(w, "could not load report", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(report)
+}
@@ -2,36 +2,725 @@ package main
 
 import (
 	"context"
-	"log"
-	"os"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
 )
 
-var rdb *redis.Client
-var ctx = context.Background()
+const presenceKeyPrefix = "presence:"
+const replicaChannelPrefix = "replica:"
+const broadcastChannel = "broadcast"
+const playerBansKey = "player:bans"
+const maintenanceKey = "maintenance"
+const deadlinesKey = "deadlines"
+const playerGamesKey = "player:games"
+const queuedAtKey = "matchmaking:queued_at"
+const reportKeyPrefix = "report:"
+const tournamentKeyPrefix = "tournament:"
+const playerStatsKeyPrefix = "player:stats:"
 
-func initRedis() {
-	redisURL := os.Getenv("REDIS_URL")
+type redisStore struct {
+	rdb *redis.Client
+}
 
-	if redisURL == "" {
-		redisURL = "redis://localhost:6379"
-		log.Printf("[REDIS] REDIS_URL not set, defaulting to %s", redisURL)
-	} else {
-		log.Printf("[REDIS] Connecting to Redis at provided URL.")
+func newRedisStore(redisURL string) *redisStore {
+	opt, err := redis.ParseURL(redisURL)
+	if err != nil {
+		fatal(storeLog, "could not parse Redis URL", "error", err)
 	}
 
-	opt, err := redis.ParseURL(redisURL)
+	s := &redisStore{rdb: redis.NewClient(opt)}
+	s.rdb.AddHook(redisMetricsHook{})
+	s.rdb.AddHook(redisTracingHook{})
+
+	if err := s.Ping(ctx); err != nil {
+		fatal(storeLog, "could not connect to Redis", "error", err)
+	}
+
+	storeLog.Info("connected to Redis", "addr", opt.Addr)
+	return s
+}
+
+func notFound(err error) error {
+	if err == redis.Nil {
+		return ErrNotFound
+	}
+	return err
+}
+
+func gameEventsKey(gameID string) string {
+	return fmt.Sprintf("game:%s:events", gameID)
+}
+
+func gameSeqKey(gameID string) string {
+	return fmt.Sprintf("game:%s:seq", gameID)
+}
+
+func gameUpdatesKey(gameID string) string {
+	return fmt.Sprintf("game:%s:updates", gameID)
+}
+
+func playerStatsKey(playerID string) string {
+	return playerStatsKeyPrefix + playerID
+}
+
+func (s *redisStore) Ping(ctx context.Context) error {
+	return s.rdb.Ping(ctx).Err()
+}
+
+// AppendGameEvents adds events to the game's stream in one MULTI, guarded by
+// WATCH so the append fails with ErrConflict if another writer got there
+// first.
+func (s *redisStore) AppendGameEvents(ctx context.Context, gameID string, afterID string, events []GameEvent) ([]string, error) {
+	key := gameEventsKey(gameID)
+	ids := make([]string, 0, len(events))
+	err := s.rdb.Watch(ctx, func(tx *redis.Tx) error {
+		last, err := tx.XRevRangeN(ctx, key, "+", "-", 1).Result()
+		if err != nil {
+			return err
+		}
+		lastID := ""
+		if len(last) > 0 {
+			lastID = last[0].ID
+		}
+		if lastID != afterID {
+			return ErrConflict
+		}
+
+		cmds, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, event := range events {
+				jsonData, err := json.Marshal(event)
+				if err != nil {
+					return err
+				}
+				pipe.XAdd(ctx, &redis.XAddArgs{Stream: key, Values: map[string]interface{}{"event": jsonData}})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, cmd := range cmds {
+			ids = append(ids, cmd.(*redis.StringCmd).Val())
+		}
+		return nil
+	}, key)
+	if err == redis.TxFailedErr {
+		return nil, ErrConflict
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// GameEvents reads the stream after afterID, or from the start when it is
+// empty. The exclusive start is computed by hand because "(" ranges need
+// Redis 6.2.
+func (s *redisStore) GameEvents(ctx context.Context, gameID string, afterID string) ([]GameEvent, error) {
+	start := "-"
+	if afterID != "" {
+		next, err := nextStreamID(afterID)
+		if err != nil {
+			return nil, err
+		}
+		start = next
+	}
+	messages, err := s.rdb.XRange(ctx, gameEventsKey(gameID), start, "+").Result()
+	if err != nil {
+		return nil, err
+	}
+	events := make([]GameEvent, 0, len(messages))
+	for _, message := range messages {
+		raw, _ := message.Values["event"].(string)
+		var event GameEvent
+		if err := json.Unmarshal([]byte(raw), &event); err != nil {
+			return nil, err
+		}
+		event.ID = message.ID
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func nextStreamID(id string) (string, error) {
+	ms, seq, ok := strings.Cut(id, "-")
+	if !ok {
+		return "", fmt.Errorf("invalid stream ID %q", id)
+	}
+	n, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid stream ID %q: %w", id, err)
+	}
+	return fmt.Sprintf("%s-%d", ms, n+1), nil
+}
+
+func (s *redisStore) ExpireGame(ctx context.Context, gameID string, ttl time.Duration) error {
+	pipe := s.rdb.TxPipeline()
+	pipe.Expire(ctx, gameEventsKey(gameID), ttl)
+	pipe.Expire(ctx, gameSeqKey(gameID), ttl)
+	pipe.Expire(ctx, gameUpdatesKey(gameID), ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisStore) NextGameUpdateSeq(ctx context.Context, gameID string) (int64, error) {
+	return s.rdb.Incr(ctx, gameSeqKey(gameID)).Result()
+}
+
+func (s *redisStore) GameUpdateSeq(ctx context.Context, gameID string) (int64, error) {
+	seq, err := s.rdb.Get(ctx, gameSeqKey(gameID)).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return seq, err
+}
+
+// BufferGameUpdate keeps the buffer as a sorted set scored by sequence
+// number, so replays come back in order even if two publishers race.
+func (s *redisStore) BufferGameUpdate(ctx context.Context, gameID string, update GameUpdate, keep int64) error {
+	key := gameUpdatesKey(gameID)
+	pipe := s.rdb.TxPipeline()
+	pipe.ZAdd(ctx, key, &redis.Z{Score: float64(update.Seq), Member: update.Message})
+	pipe.ZRemRangeByRank(ctx, key, 0, -keep-1)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisStore) GameUpdatesSince(ctx context.Context, gameID string, afterSeq int64) ([]GameUpdate, error) {
+	results, err := s.rdb.ZRangeByScoreWithScores(ctx, gameUpdatesKey(gameID), &redis.ZRangeBy{
+		Min: fmt.Sprintf("(%d", afterSeq),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	updates := make([]GameUpdate, 0, len(results))
+	for _, z := range results {
+		message, _ := z.Member.(string)
+		updates = append(updates, GameUpdate{Seq: int64(z.Score), Message: []byte(message)})
+	}
+	return updates, nil
+}
+
+// Presence is a sorted set per player: members are replica IDs, scored by
+// the time their entry lapses, so a crashed replica drops out on its own.
+func (s *redisStore) SetPresence(ctx context.Context, playerID string, replicaID string, ttl time.Duration) error {
+	key := presenceKeyPrefix + playerID
+	now := time.Now()
+	pipe := s.rdb.TxPipeline()
+	pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(now.UnixMilli(), 10))
+	pipe.ZAdd(ctx, key, &redis.Z{Score: float64(now.Add(ttl).UnixMilli()), Member: replicaID})
+	pipe.Expire(ctx, key, ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisStore) RemovePresence(ctx context.Context, playerID string, replicaID string) error {
+	return s.rdb.ZRem(ctx, presenceKeyPrefix+playerID, replicaID).Err()
+}
+
+func (s *redisStore) PlayerReplicas(ctx context.Context, playerID string) ([]string, error) {
+	return s.rdb.ZRangeByScore(ctx, presenceKeyPrefix+playerID, &redis.ZRangeBy{
+		Min: "(" + strconv.FormatInt(time.Now().UnixMilli(), 10),
+		Max: "+inf",
+	}).Result()
+}
+
+func (s *redisStore) PublishToReplica(ctx context.Context, replicaID string, message []byte) error {
+	return s.rdb.Publish(ctx, replicaChannelPrefix+replicaID, message).Err()
+}
+
+func (s *redisStore) SubscribeReplica(ctx context.Context, replicaID string) (<-chan []byte, error) {
+	return s.subscribe(ctx, replicaChannelPrefix+replicaID)
+}
+
+func (s *redisStore) PublishBroadcast(ctx context.Context, message []byte) error {
+	return s.rdb.Publish(ctx, broadcastChannel, message).Err()
+}
+
+func (s *redisStore) SubscribeBroadcast(ctx context.Context) (<-chan []byte, error) {
+	return s.subscribe(ctx, broadcastChannel)
+}
+
+// subscribe delivers the channel's messages until ctx is cancelled.
+func (s *redisStore) subscribe(ctx context.Context, channel string) (<-chan []byte, error) {
+	pubsub := s.rdb.Subscribe(ctx, channel)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+
+	messages := make(chan []byte)
+	go func() {
+		defer close(messages)
+		defer pubsub.Close()
+		ch := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+				select {
+				case messages <- []byte(msg.Payload):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return messages, nil
+}
+
+// enqueueScript queues a player only if the in-queue set did not already
+// hold them.
+var enqueueScript = redis.NewScript(`
+if redis.call("SADD", KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+redis.call("LPUSH", KEYS[2], ARGV[1])
+redis.call("HSET", KEYS[3], ARGV[1], ARGV[2])
+return 1`)
+
+func (s *redisStore) EnqueuePlayer(ctx context.Context, playerID string) (bool, error) {
+	keys := []string{inQueueKey, matchmakingQueueKey, queuedAtKey}
+	added, err := enqueueScript.Run(ctx, s.rdb, keys, playerID, time.Now().UnixMilli()).Int()
+	return added == 1, err
+}
+
+func (s *redisStore) PopQueuedPlayer(ctx context.Context) (string, time.Time, error) {
+	playerID, err := s.rdb.RPop(ctx, matchmakingQueueKey).Result()
 	if err != nil {
-		log.Fatalf("[REDIS] Could not parse Redis URL: %v", err)
+		return "", time.Time{}, notFound(err)
 	}
+	pipe := s.rdb.TxPipeline()
+	pipe.SRem(ctx, inQueueKey, playerID)
+	queuedAt := pipe.HGet(ctx, queuedAtKey, playerID)
+	pipe.HDel(ctx, queuedAtKey, playerID)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return playerID, time.Time{}, err
+	}
+	var since time.Time
+	if ms, err := queuedAt.Int64(); err == nil {
+		since = time.UnixMilli(ms)
+	}
+	return playerID, since, nil
+}
+
+func (s *redisStore) RemoveFromQueue(ctx context.Context, playerID string) error {
+	pipe := s.rdb.TxPipeline()
+	pipe.LRem(ctx, matchmakingQueueKey, 0, playerID)
+	pipe.SRem(ctx, inQueueKey, playerID)
+	pipe.HDel(ctx, queuedAtKey, playerID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisStore) IsQueued(ctx context.Context, playerID string) (bool, error) {
+	return s.rdb.SIsMember(ctx, inQueueKey, playerID).Result()
+}
+
+func (s *redisStore) QueueLength(ctx context.Context) (int64, error) {
+	return s.rdb.LLen(ctx, matchmakingQueueKey).Result()
+}
+
+func (s *redisStore) QueuedPlayers(ctx context.Context) ([]string, error) {
+	pipe := s.rdb.Pipeline()
+	members := pipe.SMembers(ctx, inQueueKey)
+	queued := pipe.LRange(ctx, matchmakingQueueKey, 0, -1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var players []string
+	for _, playerID := range append(members.Val(), queued.Val()...) {
+		if !seen[playerID] {
+			seen[playerID] = true
+			players = append(players, playerID)
+		}
+	}
+	return players, nil
+}
+
+func (s *redisStore) MarkInGame(ctx context.Context, gameID string, playerIDs ...string) error {
+	pipe := s.rdb.TxPipeline()
+	pipe.SAdd(ctx, inGameKey, toInterfaces(playerIDs)...)
+	for _, playerID := range playerIDs {
+		pipe.HSet(ctx, playerGamesKey, playerID, gameID)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisStore) ClearInGame(ctx context.Context, playerIDs ...string) error {
+	pipe := s.rdb.TxPipeline()
+	pipe.SRem(ctx, inGameKey, toInterfaces(playerIDs)...)
+	pipe.HDel(ctx, playerGamesKey, playerIDs...)
+	_, err := pipe.Exec(ctx)
+	return err
+}
 
-	rdb = redis.NewClient(opt)
+func (s *redisStore) IsInGame(ctx context.Context, playerID string) (bool, error) {
+	return s.rdb.SIsMember(ctx, inGameKey, playerID).Result()
+}
+
+func (s *redisStore) PlayersInGame(ctx context.Context) ([]string, error) {
+	return s.rdb.SMembers(ctx, inGameKey).Result()
+}
 
-	_, err = rdb.Ping(ctx).Result()
+func (s *redisStore) PlayerGame(ctx context.Context, playerID string) (string, error) {
+	gameID, err := s.rdb.HGet(ctx, playerGamesKey, playerID).Result()
+	return gameID, notFound(err)
+}
+
+// Bans live in one hash of JSON records. Expired bans are dropped when they
+// are next read.
+func (s *redisStore) BanPlayer(ctx context.Context, ban *PlayerBan) error {
+	data, err := json.Marshal(ban)
 	if err != nil {
-		log.Fatalf("[REDIS] Could not connect to Redis: %v", err)
+		return err
 	}
+	return s.rdb.HSet(ctx, playerBansKey, ban.PlayerID, data).Err()
+}
 
-	log.Println("[REDIS] Successfully connected to Redis!")
+func (s *redisStore) UnbanPlayer(ctx context.Context, playerID string) error {
+	return s.rdb.HDel(ctx, playerBansKey, playerID).Err()
+}
+
+func (s *redisStore) PlayerBan(ctx context.Context, playerID string) (*PlayerBan, error) {
+	data, err := s.rdb.HGet(ctx, playerBansKey, playerID).Bytes()
+	if err != nil {
+		return nil, notFound(err)
+	}
+	var ban PlayerBan
+	if err := json.Unmarshal(data, &ban); err != nil {
+		return nil, err
+	}
+	if ban.expired(time.Now()) {
+		s.rdb.HDel(ctx, playerBansKey, playerID)
+		return nil, ErrNotFound
+	}
+	return &ban, nil
+}
+
+func (s *redisStore) Bans(ctx context.Context) ([]PlayerBan, error) {
+	records, err := s.rdb.HGetAll(ctx, playerBansKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	bans := make([]PlayerBan, 0, len(records))
+	for playerID, data := range records {
+		var ban PlayerBan
+		if err := json.Unmarshal([]byte(data), &ban); err != nil {
+			return nil, err
+		}
+		if ban.expired(now) {
+			s.rdb.HDel(ctx, playerBansKey, playerID)
+			continue
+		}
+		bans = append(bans, ban)
+	}
+	return bans, nil
+}
+
+func (s *redisStore) SetPlayerName(ctx context.Context, playerID string, name string) error {
+	return s.rdb.HSet(ctx, playerNamesKey, playerID, name).Err()
+}
+
+func (s *redisStore) GetPlayerNames(ctx context.Context, playerIDs []string) ([]string, error) {
+	if len(playerIDs) == 0 {
+		return []string{}, nil
+	}
+	values, err := s.rdb.HMGet(ctx, playerNamesKey, playerIDs...).Result()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(values))
+	for i, value := range values {
+		if name, ok := value.(string); ok {
+			names[i] = name
+		}
+	}
+	return names, nil
+}
+
+func (s *redisStore) IncrementScore(ctx context.Context, board string, playerID string, delta float64, ttl time.Duration) error {
+	pipe := s.rdb.TxPipeline()
+	pipe.ZIncrBy(ctx, board, delta, playerID)
+	if ttl > 0 {
+		pipe.Expire(ctx, board, ttl)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisStore) SetScore(ctx context.Context, board string, playerID string, score float64) error {
+	return s.rdb.ZAdd(ctx, board, &redis.Z{Score: score, Member: playerID}).Err()
+}
+
+func (s *redisStore) RemoveScore(ctx context.Context, board string, playerID string) error {
+	return s.rdb.ZRem(ctx, board, playerID).Err()
+}
+
+func (s *redisStore) Score(ctx context.Context, board string, playerID string) (float64, error) {
+	score, err := s.rdb.ZScore(ctx, board, playerID).Result()
+	return score, notFound(err)
+}
+
+func (s *redisStore) UpdateScores(ctx context.Context, board string, playerIDs []string, fn func(scores map[string]float64)) (map[string]float64, error) {
+	for attempt := 0; attempt < 5; attempt++ {
+		scores := make(map[string]float64, len(playerIDs))
+		err := s.rdb.Watch(ctx, func(tx *redis.Tx) error {
+			for _, playerID := range playerIDs {
+				score, err := tx.ZScore(ctx, board, playerID).Result()
+				if err == redis.Nil {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				scores[playerID] = score
+			}
+			fn(scores)
+			_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				for _, playerID := range playerIDs {
+					if score, ok := scores[playerID]; ok {
+						pipe.ZAdd(ctx, board, &redis.Z{Score: score, Member: playerID})
+					}
+				}
+				return nil
+			})
+			return err
+		}, board)
+		if err == redis.TxFailedErr {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return scores, nil
+	}
+	return nil, fmt.Errorf("%s: too many concurrent updates", board)
+}
+
+func (s *redisStore) Rank(ctx context.Context, board string, playerID string) (int64, error) {
+	rank, err := s.rdb.ZRevRank(ctx, board, playerID).Result()
+	return rank, notFound(err)
+}
+
+func (s *redisStore) RangeByRank(ctx context.Context, board string, start, stop int64) ([]ScoredMember, error) {
+	idScores, err := s.rdb.ZRevRangeWithScores(ctx, board, start, stop).Result()
+	if err != nil {
+		return nil, err
+	}
+	members := make([]ScoredMember, 0, len(idScores))
+	for _, idScore := range idScores {
+		members = append(members, ScoredMember{Member: idScore.Member.(string), Score: idScore.Score})
+	}
+	return members, nil
+}
+
+func (s *redisStore) BoardSize(ctx context.Context, board string) (int64, error) {
+	return s.rdb.ZCard(ctx, board).Result()
+}
+
+func (s *redisStore) RecordPlayerResult(ctx context.Context, playerID string, outcome string, rating float64) (*PlayerStats, error) {
+	key := playerStatsKey(playerID)
+	pipe := s.rdb.TxPipeline()
+	pipe.HIncrBy(ctx, key, "games", 1)
+	switch outcome {
+	case OutcomeWin:
+		pipe.HIncrBy(ctx, key, "wins", 1)
+		pipe.HIncrBy(ctx, key, "streak", 1)
+	case OutcomeDraw:
+		pipe.HIncrBy(ctx, key, "draws", 1)
+		pipe.HSet(ctx, key, "streak", 0)
+	default:
+		pipe.HIncrBy(ctx, key, "losses", 1)
+		pipe.HSet(ctx, key, "streak", 0)
+	}
+	pipe.HSet(ctx, key, "rating", rating)
+	fields := pipe.HGetAll(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	values := fields.Val()
+	stats := &PlayerStats{}
+	stats.Games, _ = strconv.ParseInt(values["games"], 10, 64)
+	stats.Wins, _ = strconv.ParseInt(values["wins"], 10, 64)
+	stats.Losses, _ = strconv.ParseInt(values["losses"], 10, 64)
+	stats.Draws, _ = strconv.ParseInt(values["draws"], 10, 64)
+	stats.Streak, _ = strconv.ParseInt(values["streak"], 10, 64)
+	stats.Rating, _ = strconv.ParseFloat(values["rating"], 64)
+	return stats, nil
+}
+
+func (s *redisStore) SaveTournament(ctx context.Context, t *Tournament) error {
+	jsonData, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	return s.rdb.Set(ctx, tournamentKeyPrefix+t.ID, jsonData, 0).Err()
+}
+
+func (s *redisStore) GetTournament(ctx context.Context, tournamentID string) (*Tournament, error) {
+	jsonData, err := s.rdb.Get(ctx, tournamentKeyPrefix+tournamentID).Result()
+	if err != nil {
+		return nil, notFound(err)
+	}
+	var t Tournament
+	if err := json.Unmarshal([]byte(jsonData), &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// UpdateTournament runs fn inside an optimistic WATCH transaction so
+// concurrent results from different replicas cannot overwrite each other.
+func (s *redisStore) UpdateTournament(ctx context.Context, tournamentID string, fn func(t *Tournament) error) (*Tournament, error) {
+	key := tournamentKeyPrefix + tournamentID
+	for attempt := 0; attempt < 5; attempt++ {
+		var updated Tournament
+		err := s.rdb.Watch(ctx, func(tx *redis.Tx) error {
+			jsonData, err := tx.Get(ctx, key).Result()
+			if err != nil {
+				return notFound(err)
+			}
+			if err := json.Unmarshal([]byte(jsonData), &updated); err != nil {
+				return err
+			}
+			if err := fn(&updated); err != nil {
+				return err
+			}
+			newData, err := json.Marshal(&updated)
+			if err != nil {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Set(ctx, key, newData, 0)
+				return nil
+			})
+			return err
+		}, key)
+		if err == redis.TxFailedErr {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &updated, nil
+	}
+	return nil, fmt.Errorf("tournament %s: too many concurrent updates", tournamentID)
+}
+
+func (s *redisStore) SaveTournamentStandings(ctx context.Context, tournamentID string, standings []byte) error {
+	return s.rdb.Set(ctx, tournamentKeyPrefix+tournamentID+":standings", standings, 0).Err()
+}
+
+func (s *redisStore) GetSeasonArchive(ctx context.Context, season int) (*SeasonArchive, error) {
+	archiveJSON, err := s.rdb.Get(ctx, seasonArchiveKey(season)).Result()
+	if err != nil {
+		return nil, notFound(err)
+	}
+	var archive SeasonArchive
+	if err := json.Unmarshal([]byte(archiveJSON), &archive); err != nil {
+		return nil, err
+	}
+	return &archive, nil
+}
+
+func (s *redisStore) ArchiveSeason(ctx context.Context, archive *SeasonArchive) error {
+	archiveJSON, err := json.Marshal(archive)
+	if err != nil {
+		return err
+	}
+	pipe := s.rdb.TxPipeline()
+	pipe.Set(ctx, seasonArchiveKey(archive.Season), archiveJSON, 0)
+	pipe.Del(ctx, seasonLeaderboardKey(archive.Season))
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisStore) ScheduleDeadline(ctx context.Context, member string, due time.Time) error {
+	return s.rdb.ZAdd(ctx, deadlinesKey, &redis.Z{Score: float64(due.UnixMilli()), Member: member}).Err()
+}
+
+func (s *redisStore) CancelDeadline(ctx context.Context, member string) error {
+	return s.rdb.ZRem(ctx, deadlinesKey, member).Err()
+}
+
+func (s *redisStore) DueDeadlines(ctx context.Context, now time.Time, limit int64) ([]string, error) {
+	return s.rdb.ZRangeByScore(ctx, deadlinesKey, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(now.UnixMilli(), 10),
+		Count: limit,
+	}).Result()
+}
+
+func (s *redisStore) SetMaintenance(ctx context.Context, maintenance *Maintenance) error {
+	data, err := json.Marshal(maintenance)
+	if err != nil {
+		return err
+	}
+	return s.rdb.Set(ctx, maintenanceKey, data, 0).Err()
+}
+
+func (s *redisStore) ClearMaintenance(ctx context.Context) error {
+	return s.rdb.Del(ctx, maintenanceKey).Err()
+}
+
+func (s *redisStore) Maintenance(ctx context.Context) (*Maintenance, error) {
+	data, err := s.rdb.Get(ctx, maintenanceKey).Bytes()
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return parseMaintenance(data), nil
+}
+
+func (s *redisStore) SaveReport(ctx context.Context, name string, report []byte) error {
+	return s.rdb.Set(ctx, reportKeyPrefix+name, report, 0).Err()
+}
+
+func (s *redisStore) GetReport(ctx context.Context, name string) ([]byte, error) {
+	report, err := s.rdb.Get(ctx, reportKeyPrefix+name).Bytes()
+	return report, notFound(err)
+}
+
+func (s *redisStore) AcquireLock(ctx context.Context, name string, ttl time.Duration) (string, error) {
+	token := uuid.NewString()
+	acquired, err := s.rdb.SetNX(ctx, "lock:"+name, token, ttl).Result()
+	if err != nil || !acquired {
+		return "", err
+	}
+	return token, nil
+}
+
+// releaseLockScript deletes a lock only if it still holds the caller's
+// token.
+var releaseLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+func (s *redisStore) ReleaseLock(ctx context.Context, name string, token string) error {
+	return releaseLockScript.Run(ctx, s.rdb, []string{"lock:" + name}, token).Err()
+}
+
+func toInterfaces(values []string) []interface{} {
+	out := make([]interface{}, len(values))
+	for i, v := range values {
+		out[i] = v
+	}
+	return out
 }
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+type SeasonArchive struct {
+	Season     int                `json:"season"`
+	StartsAt   time.Time          `json:"startsAt"`
+	EndsAt     time.Time          `json:"endsAt"`
+	ArchivedAt time.Time          `json:"archivedAt"`
+	Standings  []LeaderboardEntry `json:"standings"`
+}
+
+var seasonLog = newLogger("season")
+
+// Seasons are numbered from SeasonEpoch in steps of SeasonLength, so every
+// replica derives the same season number from the clock alone.
+func (c LeaderboardConfig) seasonAt(t time.Time) int {
+	if t.Before(c.SeasonEpoch) {
+		return 1
+	}
+	return int(t.Sub(c.SeasonEpoch)/c.SeasonLength) + 1
+}
+
+func (c LeaderboardConfig) currentSeason() int {
+	return c.seasonAt(time.Now())
+}
+
+func (c LeaderboardConfig) seasonBounds(season int) (time.Time, time.Time) {
+	start := c.SeasonEpoch.Add(time.Duration(season-1) * c.SeasonLength)
+	return start, start.Add(c.SeasonLength)
+}
+
+func seasonLeaderboardKey(season int) string {
+	return fmt.Sprintf("%s:season:%d", leaderboardKey, season)
+}
+
+func seasonArchiveKey(season int) string {
+	return seasonLeaderboardKey(season) + ":archive"
+}
+
+// startSeasonRollover periodically archives every finished season that has
+// not been archived yet. Any replica may do the work; a short-lived lock
+// keeps them from racing each other.
+func startSeasonRollover(cfg LeaderboardConfig) {
+	seasonLog.Info("season rollover started", "length", cfg.SeasonLength.String(), "epoch", cfg.SeasonEpoch.Format(time.RFC3339), "current_season", cfg.currentSeason())
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		for season := cfg.currentSeason() - 1; season >= 1; season-- {
+			_, err := store.GetSeasonArchive(ctx, season)
+			if err == nil {
+				break
+			}
+			if err != ErrNotFound {
+				seasonLog.Error("error checking season archive", "season", season, "error", err)
+				break
+			}
+			archiveSeason(cfg, season)
+		}
+		<-ticker.C
+	}
+}
+
+func archiveSeason(cfg LeaderboardConfig, season int) {
+	lockName := fmt.Sprintf("season_archive:%d", season)
+	token, err := store.AcquireLock(ctx, lockName, 5*time.Minute)
+	if err != nil || token == "" {
+		return
+	}
+	defer store.ReleaseLock(ctx, lockName, token)
+
+	standings, err := readLeaderboard(seasonLeaderboardKey(season), 0, -1)
+	if err != nil {
+		seasonLog.Error("error reading season standings", "season", season, "error", err)
+		return
+	}
+	startsAt, endsAt := cfg.seasonBounds(season)
+	archive := &SeasonArchive{
+		Season:     season,
+		StartsAt:   startsAt,
+		EndsAt:     endsAt,
+		ArchivedAt: time.Now().UTC(),
+		Standings:  standings,
+	}
+	if err := store.ArchiveSeason(ctx, archive); err != nil {
+		seasonLog.Error("error archiving season", "season", season, "error", err)
+		return
+	}
+	seasonLog.Info("season archived", "season", season, "ranked_players", len(standings))
+}
+
+// seasonLeaderboardSource reads a season from its archive once it has been
+// rolled over, and from the live leaderboard until then.
+func seasonLeaderboardSource(season int) (leaderboardSource, error) {
+	archive, err := store.GetSeasonArchive(ctx, season)
+	if err == ErrNotFound {
+		return leaderboardSource{key: seasonLeaderboardKey(season)}, nil
+	}
+	if err != nil {
+		return leaderboardSource{}, err
+	}
+	return leaderboardSource{snapshot: archive.Standings, archived: true}, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"math"
+)
+
+const (
+	MetricWins    = "wins"
+	MetricRating  = "rating"
+	MetricWinRate = "win_rate"
+	MetricStreak  = "streak"
+)
+
+const ratingLeaderboardKey = "leaderboard:rating"
+const winRateLeaderboardKey = "leaderboard:win_rate"
+const streakLeaderboardKey = "leaderboard:streak"
+
+const initialRating = 1200.0
+const ratingK = 32.0
+
+var statsLog = newLogger("stats")
+
+// recordGameStats updates win/loss/draw counts, streaks and Elo ratings for
+// both players of a finished game.
+func recordGameStats(cfg LeaderboardConfig, game *Game) {
+	var scoreX float64
+	switch game.Status {
+	case StatusWinX:
+		scoreX = 1
+	case StatusWinO:
+		scoreX = 0
+	case StatusDraw:
+		scoreX = 0.5
+	default:
+		return
+	}
+
+	// Both ratings are read and written in one store update, so games
+	// finishing at the same time for the same player do not overwrite
+	// each other's result.
+	var deltaX float64
+	ratings, err := store.UpdateScores(ctx, ratingLeaderboardKey, []string{game.PlayerX, game.PlayerO}, func(ratings map[string]float64) {
+		ratingX, ratingO := playerRating(ratings, game.PlayerX), playerRating(ratings, game.PlayerO)
+		expectedX := 1 / (1 + math.Pow(10, (ratingO-ratingX)/400))
+		deltaX = ratingK * (scoreX - expectedX)
+		ratings[game.PlayerX] = ratingX + deltaX
+		ratings[game.PlayerO] = ratingO - deltaX
+	})
+	if err != nil {
+		// Still count the result; the ratings stay as they were.
+		statsLog.Error("error updating ratings", "game_id", game.ID, "error", err)
+		ratings = make(map[string]float64)
+		for _, playerID := range []string{game.PlayerX, game.PlayerO} {
+			if rating, err := store.Score(ctx, ratingLeaderboardKey, playerID); err == nil {
+				ratings[playerID] = rating
+			}
+		}
+	} else {
+		statsLog.Info("ratings updated", "game_id", game.ID, "status", game.Status, "delta_x", deltaX, "delta_o", -deltaX)
+	}
+
+	updatePlayerStats(cfg, game.PlayerX, scoreX, playerRating(ratings, game.PlayerX))
+	updatePlayerStats(cfg, game.PlayerO, 1-scoreX, playerRating(ratings, game.PlayerO))
+}
+
+// playerRating returns the player's rating, or the starting rating for a
+// player who has none yet.
+func playerRating(ratings map[string]float64, playerID string) float64 {
+	if rating, ok := ratings[playerID]; ok {
+		return rating
+	}
+	return initialRating
+}
+
+func updatePlayerStats(cfg LeaderboardConfig, playerID string, score float64, rating float64) {
+	outcome := OutcomeLoss
+	switch score {
+	case 1:
+		outcome = OutcomeWin
+	case 0.5:
+		outcome = OutcomeDraw
+	}
+	stats, err := store.RecordPlayerResult(ctx, playerID, outcome, rating)
+	if err != nil {
+		statsLog.Error("error updating player stats", "player_id", playerID, "error", err)
+		return
+	}
+
+	if stats.Streak > 0 {
+		err = store.SetScore(ctx, streakLeaderboardKey, playerID, float64(stats.Streak))
+	} else {
+		err = store.RemoveScore(ctx, streakLeaderboardKey, playerID)
+	}
+	if err != nil {
+		statsLog.Error("error updating streak leaderboard", "player_id", playerID, "error", err)
+	}
+	if stats.Games >= cfg.WinRateMinGames {
+		winRate := float64(stats.Wins) / float64(stats.Games)
+		if err := store.SetScore(ctx, winRateLeaderboardKey, playerID, winRate); err != nil {
+			statsLog.Error("error updating win-rate leaderboard", "player_id", playerID, "error", err)
+		}
+	}
+}
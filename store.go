@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned by Store lookups when the requested record does
+// not exist.
+var ErrNotFound = errors.New("store: not found")
+
+const (
+	OutcomeWin  = "win"
+	OutcomeDraw = "draw"
+	OutcomeLoss = "loss"
+)
+
+type ScoredMember struct {
+	Member string
+	Score  float64
+}
+
+// GameUpdate is a published game_update message and its per-game sequence
+// number.
+type GameUpdate struct {
+	Seq     int64
+	Message []byte
+}
+
+// PlayerBan keeps a player from identifying on any connection. A nil Until
+// bans them until they are unbanned.
+type PlayerBan struct {
+	PlayerID string     `json:"playerId"`
+	Reason   string     `json:"reason,omitempty"`
+	BannedAt time.Time  `json:"bannedAt"`
+	Until    *time.Time `json:"until,omitempty"`
+}
+
+func (b *PlayerBan) expired(now time.Time) bool {
+	return b.Until != nil && !now.Before(*b.Until)
+}
+
+type PlayerStats struct {
+	Games  int64   `json:"games"`
+	Wins   int64   `json:"wins"`
+	Losses int64   `json:"losses"`
+	Draws  int64   `json:"draws"`
+	Streak int64   `json:"streak"`
+	Rating float64 `json:"rating"`
+}
+
+// Store is the shared state every replica reads and writes. Leaderboards
+// are addressed by name (e.g. leaderboardKey) so new rankings need no new
+// methods.
+type Store interface {
+	Ping(ctx context.Context) error
+
+	// AppendGameEvents adds events to a game's log and returns their IDs.
+	// It fails with ErrConflict unless the log currently ends at afterID
+	// (an empty afterID requires an empty log).
+	AppendGameEvents(ctx context.Context, gameID string, afterID string, events []GameEvent) ([]string, error)
+	// GameEvents returns the events after afterID, or the whole log when
+	// afterID is empty, oldest first.
+	GameEvents(ctx context.Context, gameID string, afterID string) ([]GameEvent, error)
+	// ExpireGame schedules a finished game's event log for removal.
+	ExpireGame(ctx context.Context, gameID string, ttl time.Duration) error
+	// NextGameUpdateSeq reserves the next sequence number for a game's
+	// updates, starting at 1.
+	NextGameUpdateSeq(ctx context.Context, gameID string) (int64, error)
+	// GameUpdateSeq returns the last sequence number reserved for a game's
+	// updates, or 0 if none was.
+	GameUpdateSeq(ctx context.Context, gameID string) (int64, error)
+	// BufferGameUpdate adds the update to the game's buffer, keeping only
+	// the newest keep entries.
+	BufferGameUpdate(ctx context.Context, gameID string, update GameUpdate, keep int64) error
+	// GameUpdatesSince returns the buffered updates with a sequence number
+	// above afterSeq, oldest first.
+	GameUpdatesSince(ctx context.Context, gameID string, afterSeq int64) ([]GameUpdate, error)
+
+	// SetPresence records that replicaID holds a connection for the player
+	// for the next ttl.
+	SetPresence(ctx context.Context, playerID string, replicaID string, ttl time.Duration) error
+	RemovePresence(ctx context.Context, playerID string, replicaID string) error
+	// PlayerReplicas returns the replicas currently holding a connection
+	// for the player.
+	PlayerReplicas(ctx context.Context, playerID string) ([]string, error)
+	PublishToReplica(ctx context.Context, replicaID string, message []byte) error
+	// SubscribeReplica delivers every message published to replicaID until
+	// ctx is cancelled, at which point the channel is closed.
+	SubscribeReplica(ctx context.Context, replicaID string) (<-chan []byte, error)
+	// PublishBroadcast sends a message to every replica's broadcast
+	// subscription.
+	PublishBroadcast(ctx context.Context, message []byte) error
+	SubscribeBroadcast(ctx context.Context) (<-chan []byte, error)
+
+	// EnqueuePlayer adds the player to the back of the queue unless they
+	// are already queued, and reports whether it did. The check and the
+	// add are one operation, so concurrent requests queue a player once.
+	EnqueuePlayer(ctx context.Context, playerID string) (bool, error)
+	// PopQueuedPlayer removes the longest-waiting player from the queue and
+	// returns when they joined it (zero if unknown).
+	PopQueuedPlayer(ctx context.Context) (string, time.Time, error)
+	RemoveFromQueue(ctx context.Context, playerID string) error
+	IsQueued(ctx context.Context, playerID string) (bool, error)
+	QueueLength(ctx context.Context) (int64, error)
+	// QueuedPlayers returns everyone in either the queue or its membership
+	// set, so entries that fell out of sync can be found.
+	QueuedPlayers(ctx context.Context) ([]string, error)
+
+	// MarkInGame records that the players are busy in gameID.
+	MarkInGame(ctx context.Context, gameID string, playerIDs ...string) error
+	ClearInGame(ctx context.Context, playerIDs ...string) error
+	IsInGame(ctx context.Context, playerID string) (bool, error)
+	PlayersInGame(ctx context.Context) ([]string, error)
+	// PlayerGame returns the game a busy player was marked in, or
+	// ErrNotFound if none was recorded.
+	PlayerGame(ctx context.Context, playerID string) (string, error)
+
+	BanPlayer(ctx context.Context, ban *PlayerBan) error
+	UnbanPlayer(ctx context.Context, playerID string) error
+	// PlayerBan returns the player's ban, or ErrNotFound if they are not
+	// banned or the ban has expired.
+	PlayerBan(ctx context.Context, playerID string) (*PlayerBan, error)
+	// Bans returns every ban still in force.
+	Bans(ctx context.Context) ([]PlayerBan, error)
+
+	// SetMaintenance puts every replica into maintenance mode until
+	// ClearMaintenance. Maintenance returns ErrNotFound when it is off.
+	SetMaintenance(ctx context.Context, maintenance *Maintenance) error
+	ClearMaintenance(ctx context.Context) error
+	Maintenance(ctx context.Context) (*Maintenance, error)
+
+	SetPlayerName(ctx context.Context, playerID string, name string) error
+	// GetPlayerNames returns one name per ID, empty for unknown players.
+	GetPlayerNames(ctx context.Context, playerIDs []string) ([]string, error)
+
+	// IncrementScore adds delta to a member's score. A non-zero ttl
+	// (re)sets the leaderboard's expiry.
+	IncrementScore(ctx context.Context, board string, playerID string, delta float64, ttl time.Duration) error
+	SetScore(ctx context.Context, board string, playerID string, score float64) error
+	RemoveScore(ctx context.Context, board string, playerID string) error
+	Score(ctx context.Context, board string, playerID string) (float64, error)
+	// UpdateScores reads the players' scores, lets fn change them and
+	// writes them back atomically, so concurrent updates are not lost.
+	// Players without a score are absent from the map fn receives; only
+	// the given players' entries are written.
+	UpdateScores(ctx context.Context, board string, playerIDs []string, fn func(scores map[string]float64)) (map[string]float64, error)
+	// Rank returns the zero-based position of a member, highest score first.
+	Rank(ctx context.Context, board string, playerID string) (int64, error)
+	// RangeByRank returns members between two zero-based positions,
+	// inclusive, highest score first. A negative stop counts from the end.
+	RangeByRank(ctx context.Context, board string, start, stop int64) ([]ScoredMember, error)
+	BoardSize(ctx context.Context, board string) (int64, error)
+
+	// RecordPlayerResult applies one game outcome to a player's counters
+	// and stores their new rating, returning the updated stats.
+	RecordPlayerResult(ctx context.Context, playerID string, outcome string, rating float64) (*PlayerStats, error)
+
+	SaveTournament(ctx context.Context, t *Tournament) error
+	GetTournament(ctx context.Context, tournamentID string) (*Tournament, error)
+	// UpdateTournament applies fn atomically with respect to other
+	// updates of the same tournament, retrying on conflicts.
+	UpdateTournament(ctx context.Context, tournamentID string, fn func(t *Tournament) error) (*Tournament, error)
+	SaveTournamentStandings(ctx context.Context, tournamentID string, standings []byte) error
+
+	GetSeasonArchive(ctx context.Context, season int) (*SeasonArchive, error)
+	// ArchiveSeason stores the snapshot and drops the season's live board.
+	ArchiveSeason(ctx context.Context, archive *SeasonArchive) error
+
+	// ScheduleDeadline records member as due at the given time, replacing
+	// any earlier schedule for the same member.
+	ScheduleDeadline(ctx context.Context, member string, due time.Time) error
+	CancelDeadline(ctx context.Context, member string) error
+	// DueDeadlines returns up to limit members due at or before now,
+	// earliest first, without removing them.
+	DueDeadlines(ctx context.Context, now time.Time, limit int64) ([]string, error)
+
+	// SaveReport stores the latest JSON report of a background job.
+	SaveReport(ctx context.Context, name string, report []byte) error
+	GetReport(ctx context.Context, name string) ([]byte, error)
+
+	// AcquireLock takes a named lock for at most ttl and returns a token
+	// naming this holder, or "" if another holder already has it.
+	AcquireLock(ctx context.Context, name string, ttl time.Duration) (string, error)
+	// ReleaseLock gives the lock up if token still holds it. A holder whose
+	// lock expired and was taken over leaves the new holder's lock alone.
+	ReleaseLock(ctx context.Context, name string, token string) error
+}
+
+var store Store
+var ctx = context.Background()
+
+var storeLog = newLogger("store")
+
+// initStore selects the backend: "redis" or "memory" for a single
+// in-process replica without Redis.
+func initStore(cfg StoreConfig) {
+	switch cfg.Backend {
+	case "redis":
+		store = newRedisStore(cfg.RedisURL)
+	case "memory":
+		storeLog.Warn("using in-memory store; state is lost on restart and not shared between replicas")
+		store = newMemoryStore()
+	}
+}
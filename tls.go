@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+var tlsLog = newLogger("tls")
+
+// certReloader serves the certificate in certFile and keyFile, reloading it
+// when either file changes. Only new handshakes see the new certificate, so
+// established WebSocket connections are unaffected.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	cert     atomic.Pointer[tls.Certificate]
+	// modTime is the newer modification time of the two files at the last
+	// load. It is owned by watch after construction.
+	modTime time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	modTime, err := r.filesModTime()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	r.modTime = modTime
+	return r, nil
+}
+
+func (r *certReloader) load() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert.Store(&cert)
+	tlsCertExpiry.Set(float64(cert.Leaf.NotAfter.Unix()))
+	tlsLog.Info("certificate loaded", "subject", cert.Leaf.Subject.String(), "not_after", cert.Leaf.NotAfter)
+	return nil
+}
+
+func (r *certReloader) filesModTime() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load(), nil
+}
+
+// watch checks the files every interval until ctx is done. A pair that
+// fails to load, for example because only one file has been replaced so
+// far, leaves the current certificate in place and is retried on the next
+// tick.
+func (r *certReloader) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		modTime, err := r.filesModTime()
+		if err != nil {
+			tlsLog.Warn("error checking certificate files", "error", err)
+			continue
+		}
+		if !modTime.After(r.modTime) {
+			continue
+		}
+		if err := r.load(); err != nil {
+			tlsReloadErrors.Inc()
+			tlsLog.Warn("error reloading certificate, keeping the current one", "error", err)
+			continue
+		}
+		r.modTime = modTime
+	}
+}
+
+// redirectToHTTPS sends plain HTTP requests to the same host and path on
+// httpsPort.
+func redirectToHTTPS(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,718 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	FormatSwiss      = "swiss"
+	FormatRoundRobin = "round_robin"
+
+	TournamentRegistering = "registering"
+	TournamentRunning     = "running"
+	TournamentFinished    = "finished"
+
+	// ResultBye marks a pairing where the player sat out the round and
+	// was awarded a full point.
+	ResultBye = "bye"
+)
+
+const tournamentMaxPairingSteps = 10000
+
+type Pairing struct {
+	PlayerX string `json:"playerX"`
+	PlayerO string `json:"playerO,omitempty"`
+	GameID  string `json:"gameId,omitempty"`
+	Result  string `json:"result,omitempty"`
+}
+
+type TournamentRound struct {
+	Number   int       `json:"number"`
+	Pairings []Pairing `json:"pairings"`
+}
+
+type Tournament struct {
+	ID           string            `json:"id"`
+	Name         string            `json:"name"`
+	Format       string            `json:"format"`
+	Status       string            `json:"status"`
+	TotalRounds  int               `json:"totalRounds"`
+	CurrentRound int               `json:"currentRound"`
+	Players      []string          `json:"players"`
+	PlayerNames  map[string]string `json:"playerNames"`
+	Rounds       []TournamentRound `json:"rounds"`
+	// CreatedBy is the player who created the tournament and may start it.
+	CreatedBy  string     `json:"createdBy,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+// tournamentError is a request the tournament rules refuse. Its code is
+// sent to the client in an error message.
+type tournamentError struct {
+	code    string
+	message string
+}
+
+func (e *tournamentError) Error() string { return e.message }
+
+// refuseTournament answers a tournament request that failed. Refusals are
+// the client's doing and logged at Info; anything else is a server fault.
+func refuseTournament(client *Client, messageType, tournamentID string, err error) {
+	payload := ErrorPayload{MessageType: messageType}
+	var refusal *tournamentError
+	switch {
+	case errors.As(err, &refusal):
+		payload.Code, payload.Message = refusal.code, refusal.message
+	case errors.Is(err, ErrNotFound):
+		payload.Code, payload.Message = "tournament_not_found", "no such tournament"
+	default:
+		client.logger().Error(messageType+" failed", "tournament_id", tournamentID, "error", err)
+		client.sendError(ErrorPayload{Code: "internal_error", Message: "the request could not be completed, try again", MessageType: messageType})
+		return
+	}
+	client.logger().Info(messageType+" rejected", "tournament_id", tournamentID, "code", payload.Code)
+	client.sendError(payload)
+}
+
+type StandingsEntry struct {
+	Rank            int     `json:"rank"`
+	PlayerID        string  `json:"playerId"`
+	Name            string  `json:"name"`
+	Score           float64 `json:"score"`
+	Wins            int     `json:"wins"`
+	Draws           int     `json:"draws"`
+	Losses          int     `json:"losses"`
+	Byes            int     `json:"byes"`
+	Buchholz        float64 `json:"buchholz"`
+	SonnebornBerger float64 `json:"sonnebornBerger"`
+}
+
+type TournamentStandings struct {
+	TournamentID string            `json:"tournamentId"`
+	Name         string            `json:"name"`
+	Format       string            `json:"format"`
+	Status       string            `json:"status"`
+	Round        int               `json:"round"`
+	TotalRounds  int               `json:"totalRounds"`
+	Standings    []StandingsEntry  `json:"standings"`
+	Rounds       []TournamentRound `json:"rounds,omitempty"`
+}
+
+var tournamentLog = newLogger("tournament")
+
+func handleCreateTournament(ctx context.Context, client *Client, payload interface{}) {
+	payloadData, _ := json.Marshal(payload)
+	var createPayload CreateTournamentPayload
+	if err := json.Unmarshal(payloadData, &createPayload); err != nil {
+		client.logger().Warn("error unmarshalling create_tournament payload", "error", err)
+		return
+	}
+	if createPayload.Format != FormatSwiss && createPayload.Format != FormatRoundRobin {
+		refuseTournament(client, "create_tournament", "", &tournamentError{"invalid_format", fmt.Sprintf("unknown tournament format %q", createPayload.Format)})
+		return
+	}
+	if !identifyTournamentPlayer(ctx, client, "create_tournament", createPayload.PlayerID) {
+		return
+	}
+
+	t := &Tournament{
+		ID:          uuid.NewString(),
+		Name:        createPayload.Name,
+		Format:      createPayload.Format,
+		Status:      TournamentRegistering,
+		TotalRounds: createPayload.Rounds,
+		Players:     []string{},
+		PlayerNames: map[string]string{},
+		Rounds:      []TournamentRound{},
+		CreatedBy:   client.PlayerID,
+		CreatedAt:   time.Now().UTC(),
+	}
+	if err := saveTournament(ctx, t); err != nil {
+		refuseTournament(client, "create_tournament", t.ID, err)
+		return
+	}
+	tournamentLog.Info("tournament created", "tournament_id", t.ID, "format", t.Format, "name", t.Name)
+	sendTournamentMessage(client, "tournament_update", t)
+}
+
+func handleJoinTournament(ctx context.Context, client *Client, payload interface{}) {
+	payloadData, _ := json.Marshal(payload)
+	var joinPayload JoinTournamentPayload
+	if err := json.Unmarshal(payloadData, &joinPayload); err != nil {
+		client.logger().Warn("error unmarshalling join_tournament payload", "error", err)
+		return
+	}
+
+	if !identifyTournamentPlayer(ctx, client, "join_tournament", joinPayload.PlayerID) {
+		return
+	}
+	client.PlayerName = joinPayload.PlayerName
+	store.SetPlayerName(ctx, client.PlayerID, client.PlayerName)
+
+	t, err := store.UpdateTournament(ctx, joinPayload.TournamentID, func(t *Tournament) error {
+		if t.Status != TournamentRegistering {
+			return &tournamentError{"registration_closed", "registration is closed (status: " + t.Status + ")"}
+		}
+		if _, ok := t.PlayerNames[client.PlayerID]; ok {
+			return &tournamentError{"already_registered", "player already registered"}
+		}
+		t.Players = append(t.Players, client.PlayerID)
+		t.PlayerNames[client.PlayerID] = client.PlayerName
+		return nil
+	})
+	if err != nil {
+		refuseTournament(client, "join_tournament", joinPayload.TournamentID, err)
+		return
+	}
+	client.logger().Info("player joined tournament", "tournament_id", t.ID, "players", len(t.Players))
+	sendTournamentMessage(client, "tournament_update", t)
+}
+
+func handleStartTournament(ctx context.Context, client *Client, payload interface{}) {
+	payloadData, _ := json.Marshal(payload)
+	var startPayload TournamentPayload
+	if err := json.Unmarshal(payloadData, &startPayload); err != nil {
+		client.logger().Warn("error unmarshalling start_tournament payload", "error", err)
+		return
+	}
+	if !identifyTournamentPlayer(ctx, client, "start_tournament", startPayload.PlayerID) {
+		return
+	}
+
+	t, err := store.UpdateTournament(ctx, startPayload.TournamentID, func(t *Tournament) error {
+		// Tournaments saved before creators were recorded have no owner
+		// and can still be started by anyone.
+		if t.CreatedBy != "" && t.CreatedBy != client.PlayerID {
+			return &tournamentError{"not_creator", "only the player who created the tournament can start it"}
+		}
+		if t.Status != TournamentRegistering {
+			return &tournamentError{"already_started", "tournament already started (status: " + t.Status + ")"}
+		}
+		if len(t.Players) < 2 {
+			return &tournamentError{"not_enough_players", "at least two players are required"}
+		}
+		t.TotalRounds = plannedRounds(t.Format, len(t.Players), t.TotalRounds)
+		t.Status = TournamentRunning
+		t.beginRound()
+		return nil
+	})
+	if err != nil {
+		refuseTournament(client, "start_tournament", startPayload.TournamentID, err)
+		return
+	}
+	tournamentLog.Info("tournament started", "tournament_id", t.ID, "players", len(t.Players), "rounds", t.TotalRounds)
+	launchRound(client.hub, t)
+}
+
+func handleGetStandings(ctx context.Context, client *Client, payload interface{}) {
+	payloadData, _ := json.Marshal(payload)
+	var standingsPayload TournamentPayload
+	if err := json.Unmarshal(payloadData, &standingsPayload); err != nil {
+		client.logger().Warn("error unmarshalling get_standings payload", "error", err)
+		return
+	}
+
+	t, err := getTournament(ctx, standingsPayload.TournamentID)
+	if err != nil {
+		refuseTournament(client, "get_standings", standingsPayload.TournamentID, err)
+		return
+	}
+	sendTournamentMessage(client, "tournament_standings", t.standings())
+}
+
+// identifyTournamentPlayer binds the connection to playerID when one is
+// given. Creating, joining and starting a tournament need a known player,
+// either from the payload or from an earlier message on this connection.
+func identifyTournamentPlayer(ctx context.Context, client *Client, messageType, playerID string) bool {
+	if playerID != "" && !client.identify(ctx, playerID) {
+		return false
+	}
+	if client.PlayerID == "" {
+		refuseTournament(client, messageType, "", &tournamentError{"identify_required", "send a playerId to " + messageType})
+		return false
+	}
+	return true
+}
+
+// recordTournamentResult stores the outcome of a finished tournament game
+// and, once every game of the round is decided, pairs the next round or
+// closes the event.
+func recordTournamentResult(hub *Hub, game *Game) {
+	var roundStarted bool
+	t, err := store.UpdateTournament(ctx, game.TournamentID, func(t *Tournament) error {
+		roundStarted = false
+		if game.Round < 1 || game.Round > len(t.Rounds) {
+			return fmt.Errorf("game %s references unknown round %d", game.ID, game.Round)
+		}
+		round := &t.Rounds[game.Round-1]
+		for i := range round.Pairings {
+			if round.Pairings[i].GameID == game.ID {
+				round.Pairings[i].Result = game.Status
+			}
+		}
+		if game.Round != t.CurrentRound || !round.complete() {
+			return nil
+		}
+		if t.CurrentRound >= t.TotalRounds {
+			finishedAt := time.Now().UTC()
+			t.Status = TournamentFinished
+			t.FinishedAt = &finishedAt
+			return nil
+		}
+		t.beginRound()
+		roundStarted = true
+		return nil
+	})
+	if err != nil {
+		tournamentLog.Error("error recording tournament game result", "tournament_id", game.TournamentID, "game_id", game.ID, "error", err)
+		return
+	}
+
+	if roundStarted {
+		tournamentLog.Info("round complete, pairing next round", "tournament_id", t.ID, "round", t.CurrentRound)
+		launchRound(hub, t)
+	} else if t.Status == TournamentFinished {
+		tournamentLog.Info("tournament finished", "tournament_id", t.ID)
+		exportTournament(hub, t)
+	}
+}
+
+// launchRound creates the games for the current round and notifies every
+// participant, taking them out of the matchmaking queue. Players that are
+// not connected get the usual forfeit timer. A player still busy in another
+// game forfeits the pairing outright, and a game that cannot be created is
+// recorded as void, so the round can still complete.
+func launchRound(hub *Hub, t *Tournament) {
+	round := t.Rounds[t.CurrentRound-1]
+	var settled []*Game
+	for _, pairing := range round.Pairings {
+		if pairing.Result != "" {
+			continue
+		}
+		game := &Game{
+			ID:           pairing.GameID,
+			PlayerX:      pairing.PlayerX,
+			PlayerO:      pairing.PlayerO,
+			PlayerXName:  t.PlayerNames[pairing.PlayerX],
+			PlayerOName:  t.PlayerNames[pairing.PlayerO],
+			Board:        [9]string{},
+			Turn:         "X",
+			Status:       StatusPlaying,
+			Variant:      VariantClassic,
+			Moves:        []Move{},
+			CreatedAt:    time.Now().UTC(),
+			TournamentID: t.ID,
+			Round:        round.Number,
+		}
+		busyX, busyO := playerBusyElsewhere(game.PlayerX, game.ID), playerBusyElsewhere(game.PlayerO, game.ID)
+		if busyX || busyO {
+			// Marking them here would lose track of the game they are in.
+			tournamentLog.Info("player busy in another game, pairing forfeited", "tournament_id", t.ID, "game_id", game.ID, "x_busy", busyX, "o_busy", busyO)
+			switch {
+			case busyX && busyO:
+				game.Status = StatusVoid
+			case busyX:
+				game.Status = StatusWinO
+			default:
+				game.Status = StatusWinX
+			}
+			settled = append(settled, game)
+			continue
+		}
+		for _, playerID := range []string{game.PlayerX, game.PlayerO} {
+			if err := store.RemoveFromQueue(ctx, playerID); err != nil {
+				tournamentLog.Error("error removing tournament player from matchmaking queue", "tournament_id", t.ID, "player_id", playerID, "error", err)
+			}
+		}
+		store.MarkInGame(ctx, game.ID, game.PlayerX, game.PlayerO)
+		if err := recordGameEvents(ctx, hub, game, GameEvent{Type: EventCreated, Game: game}); errors.Is(err, ErrConflict) {
+			// Another replica already created this game.
+			continue
+		} else if err != nil {
+			tournamentLog.Error("error creating tournament game, recording it as void", "tournament_id", t.ID, "game_id", game.ID, "error", err)
+			store.ClearInGame(ctx, game.PlayerX, game.PlayerO)
+			game.Status = StatusVoid
+			settled = append(settled, game)
+			continue
+		}
+		gamesCreated.WithLabelValues("tournament").Inc()
+
+		response := Message{Type: "match_found", Payload: game}
+		responseJSON, _ := json.Marshal(response)
+		for _, playerID := range []string{game.PlayerX, game.PlayerO} {
+			if isOnline(ctx, playerID) {
+				routeDirect(ctx, &directMessage{playerID: playerID, message: responseJSON, gameID: game.ID})
+			} else {
+				go handleGameDisconnect(hub, playerID, game.ID)
+			}
+		}
+	}
+	broadcastTournament(hub, t, "tournament_standings", t.standings())
+	for _, game := range settled {
+		recordTournamentResult(hub, game)
+	}
+}
+
+// playerBusyElsewhere reports whether the player is marked in a game other
+// than gameID. A failed lookup counts as not busy so the round still starts.
+func playerBusyElsewhere(playerID string, gameID string) bool {
+	current, err := store.PlayerGame(ctx, playerID)
+	if errors.Is(err, ErrNotFound) {
+		return false
+	}
+	if err != nil {
+		tournamentLog.Error("error checking whether tournament player is busy", "player_id", playerID, "game_id", gameID, "error", err)
+		return false
+	}
+	return current != gameID
+}
+
+// exportTournament persists the final standings as JSON in Redis and, when
+// an export directory is configured, as a file on disk.
+func exportTournament(hub *Hub, t *Tournament) {
+	final := t.standings()
+	final.Rounds = t.Rounds
+	data, err := json.MarshalIndent(final, "", "  ")
+	if err != nil {
+		tournamentLog.Error("error marshalling final standings", "tournament_id", t.ID, "error", err)
+		return
+	}
+	if err := store.SaveTournamentStandings(ctx, t.ID, data); err != nil {
+		tournamentLog.Error("error saving final standings", "tournament_id", t.ID, "error", err)
+	}
+	if dir := hub.config.Tournament.ExportDir; dir != "" {
+		path := filepath.Join(dir, fmt.Sprintf("tournament-%s.json", t.ID))
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			tournamentLog.Error("error exporting final standings", "tournament_id", t.ID, "path", path, "error", err)
+		} else {
+			tournamentLog.Info("final standings exported", "tournament_id", t.ID, "path", path)
+		}
+	}
+	broadcastTournament(hub, t, "tournament_finished", final)
+}
+
+func broadcastTournament(hub *Hub, t *Tournament, messageType string, payload interface{}) {
+	response := Message{Type: messageType, Payload: payload}
+	responseJSON, _ := json.Marshal(response)
+	for _, playerID := range t.Players {
+		routeDirect(ctx, &directMessage{playerID: playerID, message: responseJSON})
+	}
+}
+
+func sendTournamentMessage(client *Client, messageType string, payload interface{}) {
+	response := Message{Type: messageType, Payload: payload}
+	responseJSON, _ := json.Marshal(response)
+	client.reply(responseJSON)
+}
+
+// plannedRounds returns the number of rounds for a tournament. Round robin
+// always plays everyone once; Swiss defaults to ceil(log2(players)) and is
+// capped so that a full schedule without rematches remains possible.
+func plannedRounds(format string, players int, requested int) int {
+	if format == FormatRoundRobin {
+		if players%2 == 1 {
+			return players
+		}
+		return players - 1
+	}
+	maxRounds := players - 1
+	if players%2 == 1 {
+		maxRounds = players
+	}
+	if requested <= 0 {
+		requested = int(math.Ceil(math.Log2(float64(players))))
+	}
+	if requested > maxRounds {
+		requested = maxRounds
+	}
+	if requested < 1 {
+		requested = 1
+	}
+	return requested
+}
+
+// beginRound appends the pairings for the next round and assigns game IDs.
+func (t *Tournament) beginRound() {
+	var pairings []Pairing
+	if t.Format == FormatRoundRobin {
+		pairings = roundRobinPairings(t.Players, t.CurrentRound)
+	} else {
+		pairings = t.swissPairings()
+	}
+	for i := range pairings {
+		if pairings[i].Result == "" {
+			pairings[i].GameID = uuid.NewString()
+		}
+	}
+	t.CurrentRound++
+	t.Rounds = append(t.Rounds, TournamentRound{Number: t.CurrentRound, Pairings: pairings})
+}
+
+func (r *TournamentRound) complete() bool {
+	for _, pairing := range r.Pairings {
+		if pairing.Result == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// roundRobinPairings schedules the given round with the circle method. The
+// first player stays fixed while the rest rotate; an odd field gets a
+// phantom opponent that translates into a bye.
+func roundRobinPairings(players []string, round int) []Pairing {
+	ids := append([]string(nil), players...)
+	if len(ids)%2 == 1 {
+		ids = append(ids, "")
+	}
+	n := len(ids)
+	rotated := make([]string, n)
+	rotated[0] = ids[0]
+	for i := 1; i < n; i++ {
+		rotated[i] = ids[1+(i-1+round)%(n-1)]
+	}
+
+	var pairings []Pairing
+	for i := 0; i < n/2; i++ {
+		a, b := rotated[i], rotated[n-1-i]
+		if a == "" {
+			a, b = b, a
+		}
+		if b == "" {
+			pairings = append(pairings, Pairing{PlayerX: a, Result: ResultBye})
+			continue
+		}
+		if (round+i)%2 == 1 {
+			a, b = b, a
+		}
+		pairings = append(pairings, Pairing{PlayerX: a, PlayerO: b})
+	}
+	return pairings
+}
+
+// swissPairings pairs players with equal or adjacent scores, avoiding
+// rematches where possible. The lowest-ranked player without a previous bye
+// sits out when the field is odd.
+func (t *Tournament) swissPairings() []Pairing {
+	standings := t.standings().Standings
+	order := make([]string, 0, len(standings))
+	for _, entry := range standings {
+		order = append(order, entry.PlayerID)
+	}
+
+	var pairings []Pairing
+	if len(order)%2 == 1 {
+		byeIndex := len(order) - 1
+		for i := len(order) - 1; i >= 0; i-- {
+			if !t.hadBye(order[i]) {
+				byeIndex = i
+				break
+			}
+		}
+		pairings = append(pairings, Pairing{PlayerX: order[byeIndex], Result: ResultBye})
+		order = append(order[:byeIndex], order[byeIndex+1:]...)
+	}
+
+	played := t.opponents()
+	steps := 0
+	pairs, ok := pairWithoutRematches(order, played, &steps)
+	if !ok {
+		tournamentLog.Warn("no rematch-free pairing found, allowing rematches", "tournament_id", t.ID, "round", t.CurrentRound+1)
+		pairs = nil
+		for i := 0; i+1 < len(order); i += 2 {
+			pairs = append(pairs, [2]string{order[i], order[i+1]})
+		}
+	}
+
+	xCounts := t.xCounts()
+	for _, pair := range pairs {
+		x, o := pair[0], pair[1]
+		if xCounts[o] < xCounts[x] {
+			x, o = o, x
+		}
+		pairings = append(pairings, Pairing{PlayerX: x, PlayerO: o})
+	}
+	return pairings
+}
+
+// pairWithoutRematches pairs the first remaining player with the highest
+// ranked opponent they have not met yet, backtracking when a choice leaves
+// the rest of the field unpairable.
+func pairWithoutRematches(order []string, played map[string]map[string]bool, steps *int) ([][2]string, bool) {
+	if len(order) == 0 {
+		return nil, true
+	}
+	*steps++
+	if *steps > tournamentMaxPairingSteps {
+		return nil, false
+	}
+	first := order[0]
+	for i := 1; i < len(order); i++ {
+		if played[first][order[i]] {
+			continue
+		}
+		rest := make([]string, 0, len(order)-2)
+		rest = append(rest, order[1:i]...)
+		rest = append(rest, order[i+1:]...)
+		if pairs, ok := pairWithoutRematches(rest, played, steps); ok {
+			return append([][2]string{{first, order[i]}}, pairs...), true
+		}
+	}
+	return nil, false
+}
+
+func (t *Tournament) opponents() map[string]map[string]bool {
+	played := make(map[string]map[string]bool)
+	for _, round := range t.Rounds {
+		for _, pairing := range round.Pairings {
+			if pairing.PlayerO == "" {
+				continue
+			}
+			if played[pairing.PlayerX] == nil {
+				played[pairing.PlayerX] = make(map[string]bool)
+			}
+			if played[pairing.PlayerO] == nil {
+				played[pairing.PlayerO] = make(map[string]bool)
+			}
+			played[pairing.PlayerX][pairing.PlayerO] = true
+			played[pairing.PlayerO][pairing.PlayerX] = true
+		}
+	}
+	return played
+}
+
+func (t *Tournament) hadBye(playerID string) bool {
+	for _, round := range t.Rounds {
+		for _, pairing := range round.Pairings {
+			if pairing.Result == ResultBye && pairing.PlayerX == playerID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (t *Tournament) xCounts() map[string]int {
+	counts := make(map[string]int)
+	for _, round := range t.Rounds {
+		for _, pairing := range round.Pairings {
+			if pairing.PlayerO != "" {
+				counts[pairing.PlayerX]++
+			}
+		}
+	}
+	return counts
+}
+
+// standings ranks players by score, then Buchholz (sum of opponents'
+// scores), then Sonneborn-Berger (scores of beaten opponents plus half the
+// scores of drawn ones), then wins, then registration order.
+func (t *Tournament) standings() TournamentStandings {
+	type game struct {
+		opponent string
+		points   float64
+	}
+	entries := make(map[string]*StandingsEntry, len(t.Players))
+	games := make(map[string][]game, len(t.Players))
+	for _, playerID := range t.Players {
+		entries[playerID] = &StandingsEntry{PlayerID: playerID, Name: t.PlayerNames[playerID]}
+	}
+
+	for _, round := range t.Rounds {
+		for _, pairing := range round.Pairings {
+			x, o := entries[pairing.PlayerX], entries[pairing.PlayerO]
+			switch pairing.Result {
+			case ResultBye:
+				x.Score++
+				x.Byes++
+			case StatusWinX:
+				x.Score++
+				x.Wins++
+				o.Losses++
+				games[x.PlayerID] = append(games[x.PlayerID], game{o.PlayerID, 1})
+				games[o.PlayerID] = append(games[o.PlayerID], game{x.PlayerID, 0})
+			case StatusWinO:
+				o.Score++
+				o.Wins++
+				x.Losses++
+				games[x.PlayerID] = append(games[x.PlayerID], game{o.PlayerID, 0})
+				games[o.PlayerID] = append(games[o.PlayerID], game{x.PlayerID, 1})
+			case StatusDraw:
+				x.Score += 0.5
+				o.Score += 0.5
+				x.Draws++
+				o.Draws++
+				games[x.PlayerID] = append(games[x.PlayerID], game{o.PlayerID, 0.5})
+				games[o.PlayerID] = append(games[o.PlayerID], game{x.PlayerID, 0.5})
+			}
+		}
+	}
+
+	standings := make([]StandingsEntry, 0, len(t.Players))
+	for _, playerID := range t.Players {
+		entry := entries[playerID]
+		for _, g := range games[playerID] {
+			opponentScore := entries[g.opponent].Score
+			entry.Buchholz += opponentScore
+			entry.SonnebornBerger += g.points * opponentScore
+		}
+		standings = append(standings, *entry)
+	}
+
+	sort.SliceStable(standings, func(i, j int) bool {
+		a, b := standings[i], standings[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.Buchholz != b.Buchholz {
+			return a.Buchholz > b.Buchholz
+		}
+		if a.SonnebornBerger != b.SonnebornBerger {
+			return a.SonnebornBerger > b.SonnebornBerger
+		}
+		return a.Wins > b.Wins
+	})
+	for i := range standings {
+		standings[i].Rank = i + 1
+	}
+
+	return TournamentStandings{
+		TournamentID: t.ID,
+		Name:         t.Name,
+		Format:       t.Format,
+		Status:       t.Status,
+		Round:        t.CurrentRound,
+		TotalRounds:  t.TotalRounds,
+		Standings:    standings,
+	}
+}
+
+func saveTournament(ctx context.Context, t *Tournament) error {
+	if err := store.SaveTournament(ctx, t); err != nil {
+		tournamentLog.Error("error saving tournament", "tournament_id", t.ID, "error", err)
+		return err
+	}
+	return nil
+}
+
+func getTournament(ctx context.Context, tournamentID string) (*Tournament, error) {
+	t, err := store.GetTournament(ctx, tournamentID)
+	if err != nil {
+		if err != ErrNotFound {
+			tournamentLog.Error("error loading tournament", "tournament_id", tournamentID, "error", err)
+		}
+		return nil, err
+	}
+	return t, nil
+}
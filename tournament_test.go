@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func playerIDs(n int) []string {
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("p%d", i+1)
+	}
+	return ids
+}
+
+func pairKey(a, b string) string {
+	if a > b {
+		a, b = b, a
+	}
+	return a + "/" + b
+}
+
+func TestRoundRobinPairings(t *testing.T) {
+	for n := 2; n <= 9; n++ {
+		t.Run(fmt.Sprintf("%d players", n), func(t *testing.T) {
+			players := playerIDs(n)
+			rounds := plannedRounds(FormatRoundRobin, n, 0)
+			met := make(map[string]int)
+			byes := make(map[string]int)
+			for round := 0; round < rounds; round++ {
+				seen := make(map[string]bool)
+				for _, p := range roundRobinPairings(players, round) {
+					for _, id := range []string{p.PlayerX, p.PlayerO} {
+						if id == "" {
+							continue
+						}
+						if seen[id] {
+							t.Errorf("round %d: %s paired twice", round+1, id)
+						}
+						seen[id] = true
+					}
+					if p.Result == ResultBye {
+						byes[p.PlayerX]++
+						continue
+					}
+					met[pairKey(p.PlayerX, p.PlayerO)]++
+				}
+				if len(seen) != n {
+					t.Errorf("round %d: %d players scheduled, want %d", round+1, len(seen), n)
+				}
+			}
+
+			if want := n * (n - 1) / 2; len(met) != want {
+				t.Errorf("%d distinct pairings, want %d", len(met), want)
+			}
+			for pair, count := range met {
+				if count != 1 {
+					t.Errorf("%s met %d times", pair, count)
+				}
+			}
+			for _, id := range players {
+				wantByes := 0
+				if n%2 == 1 {
+					wantByes = 1
+				}
+				if byes[id] != wantByes {
+					t.Errorf("%s had %d byes, want %d", id, byes[id], wantByes)
+				}
+			}
+		})
+	}
+}
+
+func TestPairWithoutRematches(t *testing.T) {
+	played := func(pairs ...[2]string) map[string]map[string]bool {
+		m := make(map[string]map[string]bool)
+		for _, p := range pairs {
+			for _, ids := range [][2]string{p, {p[1], p[0]}} {
+				if m[ids[0]] == nil {
+					m[ids[0]] = make(map[string]bool)
+				}
+				m[ids[0]][ids[1]] = true
+			}
+		}
+		return m
+	}
+
+	tests := []struct {
+		name   string
+		order  []string
+		played map[string]map[string]bool
+		want   [][2]string
+		wantOK bool
+	}{
+		{
+			name:   "no history pairs in ranking order",
+			order:  []string{"a", "b", "c", "d"},
+			played: played(),
+			want:   [][2]string{{"a", "b"}, {"c", "d"}},
+			wantOK: true,
+		},
+		{
+			name:   "skips a rematch for the top player",
+			order:  []string{"a", "b", "c", "d"},
+			played: played([2]string{"a", "b"}),
+			want:   [][2]string{{"a", "c"}, {"b", "d"}},
+			wantOK: true,
+		},
+		{
+			name:   "backtracks when the greedy choice strands the rest",
+			order:  []string{"a", "b", "c", "d"},
+			played: played([2]string{"c", "d"}),
+			want:   [][2]string{{"a", "c"}, {"b", "d"}},
+			wantOK: true,
+		},
+		{
+			name:   "everyone has met",
+			order:  []string{"a", "b", "c", "d"},
+			played: played([2]string{"a", "b"}, [2]string{"a", "c"}, [2]string{"a", "d"}, [2]string{"b", "c"}, [2]string{"b", "d"}, [2]string{"c", "d"}),
+			wantOK: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			steps := 0
+			got, ok := pairWithoutRematches(tt.order, tt.played, &steps)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pairs = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestSwissAvoidsRematches plays whole Swiss events with deterministic
+// results and checks that no two players meet twice and nobody gets a
+// second bye.
+func TestSwissAvoidsRematches(t *testing.T) {
+	for n := 3; n <= 10; n++ {
+		t.Run(fmt.Sprintf("%d players", n), func(t *testing.T) {
+			tour := &Tournament{
+				ID:          "t",
+				Format:      FormatSwiss,
+				Players:     playerIDs(n),
+				PlayerNames: map[string]string{},
+			}
+			tour.TotalRounds = plannedRounds(FormatSwiss, n, 0)
+			met := make(map[string]bool)
+			byes := make(map[string]int)
+			for r := 0; r < tour.TotalRounds; r++ {
+				tour.beginRound()
+				round := &tour.Rounds[len(tour.Rounds)-1]
+				for i := range round.Pairings {
+					p := &round.Pairings[i]
+					if p.Result == ResultBye {
+						byes[p.PlayerX]++
+						continue
+					}
+					key := pairKey(p.PlayerX, p.PlayerO)
+					if met[key] {
+						t.Errorf("round %d: rematch %s", round.Number, key)
+					}
+					met[key] = true
+					// Vary the results so scores spread out.
+					switch (r + i) % 3 {
+					case 0:
+						p.Result = StatusWinX
+					case 1:
+						p.Result = StatusWinO
+					default:
+						p.Result = StatusDraw
+					}
+				}
+			}
+			for id, count := range byes {
+				if count > 1 {
+					t.Errorf("%s had %d byes", id, count)
+				}
+			}
+		})
+	}
+}
+
+func TestStandingsTiebreaks(t *testing.T) {
+	pair := func(x, o, result string) Pairing { return Pairing{PlayerX: x, PlayerO: o, Result: result} }
+	bye := func(x string) Pairing { return Pairing{PlayerX: x, Result: ResultBye} }
+
+	tests := []struct {
+		name    string
+		players []string
+		rounds  [][]Pairing
+		want    []string
+	}{
+		{
+			name:    "score, then registration order",
+			players: []string{"a", "b", "c", "d"},
+			rounds:  [][]Pairing{{pair("a", "b", StatusWinX), pair("c", "d", StatusDraw)}},
+			want:    []string{"a", "c", "d", "b"},
+		},
+		{
+			name:    "buchholz breaks equal scores",
+			players: []string{"c", "a", "b", "d"},
+			rounds: [][]Pairing{
+				{pair("a", "b", StatusWinX), pair("c", "d", StatusWinX)},
+				{pair("a", "c", StatusDraw), pair("b", "d", StatusWinX)},
+			},
+			// a and c both have 1.5; a's opponents scored 2.5, c's 1.5.
+			want: []string{"a", "c", "b", "d"},
+		},
+		{
+			name:    "sonneborn-berger breaks equal buchholz",
+			players: []string{"b", "a", "c", "d"},
+			rounds: [][]Pairing{
+				{pair("a", "c", StatusWinX), pair("b", "d", StatusWinX)},
+				{pair("d", "a", StatusWinX), pair("c", "b", StatusWinX)},
+				{pair("a", "b", StatusDraw), pair("c", "d", StatusWinX)},
+			},
+			// a and b both have 1.5 and Buchholz 4.5; a beat c (2), b beat d (1).
+			want: []string{"c", "a", "b", "d"},
+		},
+		{
+			name:    "wins break a tie with a bye",
+			players: []string{"x", "y", "z"},
+			rounds:  [][]Pairing{{bye("x"), pair("y", "z", StatusWinX)}},
+			want:    []string{"y", "x", "z"},
+		},
+		{
+			name:    "void and abandoned games score nothing",
+			players: []string{"a", "b", "c", "d"},
+			rounds:  [][]Pairing{{pair("a", "b", StatusVoid), pair("c", "d", StatusAbandoned)}},
+			want:    []string{"a", "b", "c", "d"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tour := &Tournament{Players: tt.players, PlayerNames: map[string]string{}}
+			for i, pairings := range tt.rounds {
+				tour.Rounds = append(tour.Rounds, TournamentRound{Number: i + 1, Pairings: pairings})
+			}
+			var got []string
+			for i, entry := range tour.standings().Standings {
+				if entry.Rank != i+1 {
+					t.Errorf("%s has rank %d at position %d", entry.PlayerID, entry.Rank, i+1)
+				}
+				got = append(got, entry.PlayerID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("order = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/Pranay-ai/tic-tac-toe-be"
+
+var tracingLog = newLogger("tracing")
+
+// tracer is a no-op until initTracing installs an exporter.
+var tracer = otel.Tracer(tracerName)
+
+// tracerProvider is nil when tracing is disabled.
+var tracerProvider *sdktrace.TracerProvider
+
+// initTracing configures the span exporter: "otlp" sends spans over
+// OTLP/HTTP (honouring the standard OTEL_EXPORTER_OTLP_* variables),
+// "stdout" prints them, and "none" disables tracing.
+func initTracing(cfg TracingConfig) {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.Exporter {
+	case "none":
+		return
+	case "otlp":
+		exporter, err = otlptracehttp.New(context.Background())
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	}
+	if err != nil {
+		fatal(tracingLog, "could not create span exporter", "error", err)
+	}
+
+	res, err := resource.New(context.Background(),
+		resource.WithAttributes(
+			attribute.String("service.name", "tic-tac-toe-be"),
+			attribute.String("service.instance.id", replicaID),
+		),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		tracingLog.Warn("error building trace resource", "error", err)
+	}
+
+	tracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	tracer = tracerProvider.Tracer(tracerName)
+	tracingLog.Info("exporting spans", "exporter", cfg.Exporter)
+}
+
+// shutdownTracing flushes any spans still buffered for export.
+func shutdownTracing(ctx context.Context) {
+	if tracerProvider == nil {
+		return
+	}
+	if err := tracerProvider.Shutdown(ctx); err != nil {
+		tracingLog.Error("error flushing spans", "error", err)
+	}
+}
+
+// injectTrace returns ctx's trace context as a carrier for a message
+// payload, or nil if ctx carries no span.
+func injectTrace(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return nil
+	}
+	return carrier
+}
+
+// extractTrace returns a context carrying the trace context from a message
+// payload.
+func extractTrace(carrier map[string]string) context.Context {
+	return otel.GetTextMapPropagator().Extract(context.Background(), propagation.MapCarrier(carrier))
+}
+
+type redisSpanKey struct{}
+
+// redisTracingHook records a span for each Redis command or pipeline issued
+// within a traced operation. Calls made outside a trace, such as the
+// background polling loops, are not recorded.
+type redisTracingHook struct{}
+
+func (redisTracingHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return startRedisSpan(ctx, cmd.Name()), nil
+}
+
+func (redisTracingHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	endRedisSpan(ctx, cmd.Err())
+	return nil
+}
+
+func (redisTracingHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	ctx = startRedisSpan(ctx, "pipeline")
+	if span, ok := ctx.Value(redisSpanKey{}).(trace.Span); ok {
+		names := make([]string, len(cmds))
+		for i, cmd := range cmds {
+			names[i] = cmd.Name()
+		}
+		span.SetAttributes(attribute.StringSlice("db.redis.commands", names))
+	}
+	return ctx, nil
+}
+
+func (redisTracingHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if cmdErr := cmd.Err(); cmdErr != nil && err == nil {
+			err = cmdErr
+		}
+	}
+	endRedisSpan(ctx, err)
+	return nil
+}
+
+func startRedisSpan(ctx context.Context, command string) context.Context {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx
+	}
+	ctx, span := tracer.Start(ctx, "redis."+command,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "redis"),
+			attribute.String("db.operation", command),
+		),
+	)
+	return context.WithValue(ctx, redisSpanKey{}, span)
+}
+
+func endRedisSpan(ctx context.Context, err error) {
+	span, ok := ctx.Value(redisSpanKey{}).(trace.Span)
+	if !ok {
+		return
+	}
+	if err != nil && !errors.Is(err, redis.Nil) && !errors.Is(err, redis.TxFailedErr) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}